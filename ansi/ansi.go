@@ -100,3 +100,33 @@ func StripAnsi(buf []byte) []byte {
 	}
 	return ret[:j]
 }
+
+// Truncate returns the first width visible characters of s.  Escape
+// sequences are kept and do not count towards width.  If s is truncated,
+// the attributes are reset so colors do not leak past the cut.
+func Truncate(s string, width int) string {
+	ret := make([]rune, 0, len(s))
+	runes := []rune(s)
+	n := 0
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == 27 && i+1 < len(runes) && runes[i+1] == '[' {
+			j := i + 2
+			for j < len(runes) && (runes[j] < '@' || runes[j] > '~') {
+				j += 1
+			}
+			if j < len(runes) {
+				j += 1
+			}
+			ret = append(ret, runes[i:j]...)
+			i = j - 1
+			continue
+		}
+
+		if n == width {
+			return string(ret) + "\x1b[0m"
+		}
+		ret = append(ret, runes[i])
+		n += 1
+	}
+	return string(ret)
+}
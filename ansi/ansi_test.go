@@ -69,3 +69,20 @@ func TestMixedColor(t *testing.T) {
 		t.Fatalf("Expected '\033[0;34mtest\033[0m'. Got %s", stripped)
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	truncated := Truncate("\033[0;34mtest\033[0m line", 2)
+	if truncated != "\033[0;34mte\033[0m" {
+		t.Fatalf("Expected '\033[0;34mte\033[0m'. Got %#v", truncated)
+	}
+
+	truncated = Truncate("\033[0;34mtest\033[0m", 4)
+	if truncated != "\033[0;34mtest\033[0m" {
+		t.Fatalf("Expected '\033[0;34mtest\033[0m'. Got %#v", truncated)
+	}
+
+	truncated = Truncate("héllo", 2)
+	if truncated != "hé\033[0m" {
+		t.Fatalf("Expected 'hé'. Got %#v", truncated)
+	}
+}
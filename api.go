@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/logger"
+	"github.com/jwilder/hud/metrics"
+)
+
+// api serves what hud currently sees as JSON: the last metrics flushed, the
+// containers being tailed, recent docker events and a stream of logs.
+type api struct {
+	sync.Mutex
+	dockerC *docker.Collectors
+	latest  *metrics.Collection
+}
+
+func newAPI(dockerC *docker.Collectors) *api {
+	return &api{
+		dockerC: dockerC,
+	}
+}
+
+func (a *api) register(mux *http.ServeMux) {
+	mux.HandleFunc("/metrics", a.serveMetrics)
+	mux.HandleFunc("/containers", a.serveContainers)
+	mux.HandleFunc("/events", a.serveEvents)
+	mux.HandleFunc("/logs", a.serveLogs)
+}
+
+// SendForever keeps the last collection flushed.
+func (a *api) SendForever(metrics chan *metrics.Collection) {
+	for snap := range metrics {
+		a.Lock()
+		a.latest = snap
+		a.Unlock()
+	}
+}
+
+// serveMetrics returns the last metrics flushed.
+func (a *api) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	latest := a.latest
+	a.Unlock()
+
+	if latest == nil {
+		http.Error(w, "no metrics collected yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"time":    latest.Time(),
+		"metrics": latest,
+	})
+}
+
+// serveContainers returns the containers whose logs are tailed.
+func (a *api) serveContainers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.dockerC.Containers())
+}
+
+// serveEvents returns the recent docker events, oldest first.
+func (a *api) serveEvents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.dockerC.RecentEvents())
+}
+
+// serveLogs follows the logs as server-sent events, one JSON log record per
+// event.  The container and stream query parameters filter the logs like the
+// filter of a log destination and can be repeated.
+func (a *api) serveLogs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := logger.Filter{
+		Containers: query["container"],
+		Streams:    query["stream"],
+	}
+	for _, pattern := range filter.Containers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			http.Error(w, fmt.Sprintf("bad container pattern %q", pattern), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := &logSubscriber{
+		filter: filter,
+		logs:   make(chan *docker.LogRecord, 100),
+	}
+	a.dockerC.AddLogHandler(sub)
+	defer a.dockerC.RemoveLogHandler(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	f := &logger.JSONFormatter{}
+	for {
+		select {
+		case rec := <-sub.logs:
+			line, err := f.Format(rec)
+			if err != nil {
+				log.Errorf("ERROR: %s", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", bytes.TrimSpace(line)); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// logSubscriber queues the logs of a log stream.  Logs are dropped rather
+// than slowing down the other log handlers if the client falls behind.
+type logSubscriber struct {
+	filter logger.Filter
+	logs   chan *docker.LogRecord
+}
+
+func (s *logSubscriber) String() string {
+	return "api"
+}
+
+func (s *logSubscriber) HandleLog(rec *docker.LogRecord) error {
+	if !s.filter.Match(rec) {
+		return nil
+	}
+
+	select {
+	case s.logs <- rec:
+		return nil
+	default:
+		return fmt.Errorf("log stream client is too slow")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Errorf("ERROR: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,372 @@
+/*
+Package config loads hud settings from a YAML or TOML file.  The file accepts
+the same options as the command line flags, plus per-destination log settings
+that cannot be expressed with -log-to.
+*/
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	Hostname        string           `yaml:"hostname"`
+	Prefix          string           `yaml:"prefix"`
+	FlushInterval   int              `yaml:"flush_interval"`
+	Debug           bool             `yaml:"debug"`
+	NoLogs          bool             `yaml:"no_logs"`
+	NoStats         bool             `yaml:"no_stats"`
+	HTTPAddr        string           `yaml:"http_addr"`
+	ShutdownTimeout string           `yaml:"shutdown_timeout"`
+	StateFile       string           `yaml:"state_file"`
+	MaxLineSize     int              `yaml:"max_line_size"`
+	Docker          DockerConfig     `yaml:"docker"`
+	Containers      ContainersConfig `yaml:"containers"`
+	Metrics         MetricsConfig    `yaml:"metrics"`
+	Logs            []LogDestination `yaml:"logs"`
+	Multiline       []Multiline      `yaml:"multiline"`
+	LogRules        []LogRule        `yaml:"log_rules"`
+	Redact          []Redact         `yaml:"redact"`
+	RateLimits      []RateLimit      `yaml:"rate_limits"`
+	Parse           []Parse          `yaml:"parse"`
+}
+
+// DockerConfig configures the connection to the docker daemons.  Endpoints
+// lists the daemons to watch besides Endpoint.  TLS is used if TLSVerify is
+// set or certificate files are given.  TLS files default to the files in
+// DOCKER_CERT_PATH and are shared by all daemons.  Container cpu
+// and memory are read from the cgroups of this host, so only local daemons
+// report them.
+type DockerConfig struct {
+	Endpoint  string           `yaml:"endpoint"`
+	Endpoints []DockerEndpoint `yaml:"endpoints"`
+	TLSVerify bool             `yaml:"tls_verify"`
+	TLSCACert string           `yaml:"tls_ca_cert"`
+	TLSCert   string           `yaml:"tls_cert"`
+	TLSKey    string           `yaml:"tls_key"`
+}
+
+// DockerEndpoint is a docker daemon to watch.  Name tags its logs and
+// metrics and defaults to the host of Endpoint.
+type DockerEndpoint struct {
+	Endpoint string `yaml:"endpoint"`
+	Name     string `yaml:"name"`
+}
+
+type MetricsConfig struct {
+	FlatNames  bool             `yaml:"flat_names"`
+	Graphite   GraphiteConfig   `yaml:"graphite"`
+	StatsD     StatsDConfig     `yaml:"statsd"`
+	InfluxDB   InfluxDBConfig   `yaml:"influxdb"`
+	Prometheus PrometheusConfig `yaml:"prometheus"`
+	Spool      SpoolConfig      `yaml:"spool"`
+}
+
+type GraphiteConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type StatsDConfig struct {
+	Addr string `yaml:"addr"`
+	MTU  int    `yaml:"mtu"`
+	Tags bool   `yaml:"tags"`
+}
+
+type InfluxDBConfig struct {
+	URL             string `yaml:"url"`
+	Database        string `yaml:"database"`
+	RetentionPolicy string `yaml:"retention_policy"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	Org             string `yaml:"org"`
+	Bucket          string `yaml:"bucket"`
+	Token           string `yaml:"token"`
+	Precision       string `yaml:"precision"`
+	Gzip            bool   `yaml:"gzip"`
+}
+
+type PrometheusConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+type SpoolConfig struct {
+	Dir     string `yaml:"dir"`
+	MaxSize int    `yaml:"max_size"`
+}
+
+// LogDestination is a log destination.  Dest is either "console" or a
+// [tcp|udp|tls]://host:port URL.  A destination with routes receives the
+// logs matching any of them; Filter is a shorthand for a single route.
+type LogDestination struct {
+	Dest     string    `yaml:"dest"`
+	Format   string    `yaml:"format"`
+	Facility string    `yaml:"facility"`
+	Severity string    `yaml:"severity"`
+	TLS      TLSConfig `yaml:"tls"`
+	Filter   Filter    `yaml:"filter"`
+	Routes   []Route   `yaml:"routes"`
+}
+
+// TLSConfig configures tls:// log destinations.  CACert replaces the system
+// roots and Cert and Key enable client certificate authentication.
+type TLSConfig struct {
+	CACert             string `yaml:"ca_cert"`
+	Cert               string `yaml:"cert"`
+	Key                string `yaml:"key"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Filter restricts the logs sent to a destination.  Containers are glob
+// patterns matched against container names.  Empty lists match everything.
+type Filter struct {
+	Containers []string `yaml:"containers"`
+	Streams    []string `yaml:"streams"`
+}
+
+// Route selects the logs sent to a destination by container, stream and
+// message.  Message is a regular expression.  Name tags the metrics of the
+// route and defaults to its index.
+type Route struct {
+	Name     string `yaml:"name"`
+	Selector `yaml:",inline"`
+	Streams  []string `yaml:"streams"`
+	Message  string   `yaml:"message"`
+}
+
+// Selector selects containers by name, image and labels.  Names, images
+// and label values are glob patterns.
+type Selector struct {
+	Containers []string          `yaml:"containers"`
+	Images     []string          `yaml:"images"`
+	Labels     map[string]string `yaml:"labels"`
+}
+
+// ContainersConfig selects the containers hud tails and measures: those
+// matching one of Include, or every container if it is empty, and none of
+// Exclude.
+type ContainersConfig struct {
+	Include []Selector `yaml:"include"`
+	Exclude []Selector `yaml:"exclude"`
+}
+
+// LogRule drops, or keeps, the log lines of the selected containers and
+// streams whose message matches Match, a regular expression, or contains
+// Contains.  Action is drop or keep and defaults to drop.  The first
+// matching rule decides.  Name tags the metrics of the rule and defaults
+// to its index.
+type LogRule struct {
+	Name     string `yaml:"name"`
+	Action   string `yaml:"action"`
+	Selector `yaml:",inline"`
+	Streams  []string `yaml:"streams"`
+	Match    string   `yaml:"match"`
+	Contains string   `yaml:"contains"`
+}
+
+// Redact replaces sensitive data in the log lines of the selected
+// containers before they are formatted.  Builtins names built-in patterns:
+// bearer, aws, email, card or all.  Rules are applied after them.
+type Redact struct {
+	Selector `yaml:",inline"`
+	Builtins []string     `yaml:"builtins"`
+	Rules    []RedactRule `yaml:"rules"`
+}
+
+// RedactRule replaces the matches of Pattern, a regular expression, with
+// Replacement, which can refer to submatches as $1 and defaults to
+// [REDACTED].  Name tags the metrics of the rule and defaults to its index.
+type RedactRule struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// RateLimit limits the log lines of each selected container to Rate lines
+// per second, with bursts of Burst lines, and keeps one line in Sample.
+// The first matching limit applies.
+type RateLimit struct {
+	Selector `yaml:",inline"`
+	Rate     float64 `yaml:"rate"`
+	Burst    int     `yaml:"burst"`
+	Sample   int     `yaml:"sample"`
+}
+
+// Parse parses the JSON or logfmt log lines of the selected containers
+// into fields.  Format is json, logfmt or auto, the default, to detect
+// either.  The first matching entry applies.
+type Parse struct {
+	Selector `yaml:",inline"`
+	Format   string `yaml:"format"`
+}
+
+// Multiline joins the lines of log events, like stack traces, of the
+// selected containers.  Timeout is a duration such as 500ms.
+type Multiline struct {
+	Selector `yaml:",inline"`
+	Start    string `yaml:"start"`
+	Continue string `yaml:"continue"`
+	MaxLines int    `yaml:"max_lines"`
+	MaxBytes int    `yaml:"max_bytes"`
+	Timeout  string `yaml:"timeout"`
+}
+
+// Load reads and validates a config file.  Files ending in .toml are parsed
+// as TOML, anything else as YAML.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parse(data, filepath.Ext(path) == ".toml")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return config, nil
+}
+
+func parse(data []byte, isTOML bool) (*Config, error) {
+	var raw interface{}
+	if isTOML {
+		// TOML is converted to YAML so both formats share the yaml keys
+		// of Config.
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = yaml.Marshal(doc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	errs := Errors{}
+	checkKeys("", raw, reflect.TypeOf(Config{}), &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// checkKeys reports keys of a decoded document that do not exist in t and
+// values that do not have the type of their key.
+func checkKeys(path string, v interface{}, t reflect.Type, errs *Errors) {
+	if v == nil {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			errs.add(path, "expected a table of keys, got %v", v)
+			return
+		}
+
+		fields := map[string]reflect.Type{}
+		structFields(t, fields)
+
+		for k, val := range m {
+			key := fmt.Sprintf("%v", k)
+			ft, ok := fields[key]
+			if !ok {
+				errs.add(joinKey(path, key), "unknown key")
+				continue
+			}
+			checkKeys(joinKey(path, key), val, ft, errs)
+		}
+	case reflect.Slice:
+		items, ok := v.([]interface{})
+		if !ok {
+			errs.add(path, "expected a list, got %v", v)
+			return
+		}
+		for i, item := range items {
+			checkKeys(path+"["+strconv.Itoa(i)+"]", item, t.Elem(), errs)
+		}
+	case reflect.Int:
+		if _, ok := v.(int); !ok {
+			errs.add(path, "expected a number, got %v", v)
+		}
+	case reflect.Float64:
+		switch v.(type) {
+		case int, float64:
+		default:
+			errs.add(path, "expected a number, got %v", v)
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			errs.add(path, "expected true or false, got %v", v)
+		}
+	}
+}
+
+// structFields adds the yaml keys of the fields of t, including those of
+// inlined structs, to fields.
+func structFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		if len(tag) > 1 && tag[1] == "inline" {
+			structFields(f.Type, fields)
+			continue
+		}
+		fields[tag[0]] = f.Type
+	}
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// KeyError is a problem with the value of a config key.
+type KeyError struct {
+	Key string
+	Err string
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Err)
+}
+
+// Errors lists all problems found in a config file.
+type Errors []*KeyError
+
+func (e Errors) Error() string {
+	lines := []string{}
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	sort.Strings(lines)
+	return "invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (e *Errors) add(key, format string, args ...interface{}) {
+	*e = append(*e, &KeyError{Key: key, Err: fmt.Sprintf(format, args...)})
+}
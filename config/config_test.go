@@ -0,0 +1,162 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	config, err := parse([]byte(`
+hostname: web-1
+flush_interval: 10
+docker:
+  endpoint: tcp://docker.example.com:2376
+  endpoints:
+    - endpoint: tcp://build-1.example.com:2376
+      name: build-1
+  tls_verify: true
+metrics:
+  statsd:
+    addr: localhost:8125
+    tags: true
+logs:
+  - dest: tls://logs.example.com:6514
+    format: syslog
+    facility: local3
+    severity: notice
+    filter:
+      containers: ["web-*"]
+      streams: [stderr]
+containers:
+  exclude:
+    - images: ["*-sidecar*"]
+      labels:
+        tier: debug
+`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := config.Flags()
+	expected := map[string]string{
+		"hostname":       "web-1",
+		"flush-interval": "10",
+		"statsd-addr":    "localhost:8125",
+		"statsd-tags":    "true",
+		"tlsverify":      "true",
+	}
+	if len(flags) != len(expected) {
+		t.Fatalf("expected flags %v, got %v", expected, flags)
+	}
+	for name, value := range expected {
+		if flags[name] != value {
+			t.Fatalf("expected %s=%s, got %s", name, value, flags[name])
+		}
+	}
+
+	if len(config.Docker.Endpoints) != 1 || config.Docker.Endpoints[0].Name != "build-1" {
+		t.Fatalf("unexpected docker endpoints: %+v", config.Docker.Endpoints)
+	}
+
+	if len(config.Logs) != 1 || config.Logs[0].Facility != "local3" || config.Logs[0].Filter.Streams[0] != "stderr" {
+		t.Fatalf("unexpected logs: %+v", config.Logs)
+	}
+
+	filter := config.Containers.Filter()
+	if filter.Logs("proxy", "envoy-sidecar:1.0", map[string]string{"tier": "debug"}) || !filter.Logs("web", "nginx", nil) {
+		t.Fatalf("unexpected container filter: %+v", filter)
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	config, err := parse([]byte(`
+prefix = "hud"
+
+[metrics.influxdb]
+url = "http://localhost:8086"
+database = "hud"
+
+[[logs]]
+dest = "console"
+format = "ext"
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Prefix != "hud" || config.Metrics.InfluxDB.Database != "hud" {
+		t.Fatalf("unexpected config: %+v", config)
+	}
+	if len(config.Logs) != 1 || config.Logs[0].Format != "ext" {
+		t.Fatalf("unexpected logs: %+v", config.Logs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := parse([]byte(`
+flush_interval: often
+metrics:
+  graphit:
+    addr: localhost:2003
+logs:
+  - dest: console
+  - dest: http://logs.example.com
+    format: xml
+    severity: loud
+    filter:
+      streams: [stdin]
+`), false)
+
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("expected Errors, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %s", err)
+	}
+	for _, key := range []string{"flush_interval: expected a number", "metrics.graphit: unknown key"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %q in:\n%s", key, err)
+		}
+	}
+
+	_, err = parse([]byte(`
+docker:
+  endpoint: http://docker.example.com
+  endpoints:
+    - endpoint: tcp://build-1:2376
+      name: build
+    - endpoint: tcp://build-2:2376
+      name: build
+logs:
+  - dest: console
+  - dest: http://logs.example.com
+    format: xml
+    severity: loud
+    filter:
+      streams: [stdin]
+  - dest: console
+    routes:
+      - name: errors
+        streams: [stderr]
+        message: "(unclosed"
+      - name: errors
+        labels:
+          env: "[prod"
+log_rules:
+  - action: ignore
+    streams: [stdin]
+redact:
+  - builtins: [phone]
+    rules:
+      - pattern: "(unclosed"
+`), false)
+	for _, key := range []string{"docker.endpoint:", "docker.endpoints[1].name:", "logs[1].dest:", "logs[1].format:", "logs[1].severity:", "logs[1].filter.streams[0]:",
+		"logs[2].routes[0].message:", "logs[2].routes[1]: bad pattern", "logs[2].routes[1].name:",
+		"log_rules[0].action:", "log_rules[0].streams[0]:", "log_rules[0]: match or contains is required",
+		"redact[0].builtins[0]:", "redact[0].rules[0].pattern:"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("expected %q in:\n%s", key, err)
+		}
+	}
+}
@@ -0,0 +1,490 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/logger"
+)
+
+var (
+	logFormats    = map[string]bool{"short": true, "ext": true, "json": true, "syslog": true}
+	logStreams    = map[string]bool{"stdout": true, "stderr": true}
+	logSchemes    = map[string]bool{"udp": true, "tcp": true, "tls": true}
+	dockerSchemes = map[string]bool{"unix": true, "tcp": true}
+	influxPrecs   = map[string]bool{"ns": true, "us": true, "ms": true, "s": true}
+)
+
+// Validate checks the values of a config and returns Errors listing every
+// invalid key.
+func (c *Config) Validate() error {
+	errs := Errors{}
+
+	if c.FlushInterval < 0 {
+		errs.add("flush_interval", "must be positive")
+	}
+	if c.ShutdownTimeout != "" {
+		if _, err := time.ParseDuration(c.ShutdownTimeout); err != nil {
+			errs.add("shutdown_timeout", "%s", err)
+		}
+	}
+	if c.MaxLineSize < 0 {
+		errs.add("max_line_size", "must be positive")
+	}
+
+	if c.Docker.Endpoint != "" {
+		validateEndpoint("docker.endpoint", c.Docker.Endpoint, &errs)
+	}
+	names := map[string]bool{}
+	for i, d := range c.Docker.Endpoints {
+		key := fmt.Sprintf("docker.endpoints[%d]", i)
+		if d.Endpoint == "" {
+			errs.add(key+".endpoint", "is required")
+		} else {
+			validateEndpoint(key+".endpoint", d.Endpoint, &errs)
+		}
+		if d.Name != "" && names[d.Name] {
+			errs.add(key+".name", "duplicate name %q", d.Name)
+		}
+		names[d.Name] = true
+	}
+
+	m := c.Metrics
+	if m.StatsD.MTU < 0 {
+		errs.add("metrics.statsd.mtu", "must be positive")
+	}
+	if m.InfluxDB.Precision != "" && !influxPrecs[m.InfluxDB.Precision] {
+		errs.add("metrics.influxdb.precision", "unsupported precision %q, expected ns, us, ms or s", m.InfluxDB.Precision)
+	}
+	if m.Spool.MaxSize < 0 {
+		errs.add("metrics.spool.max_size", "must be positive")
+	}
+
+	for i, dest := range c.Logs {
+		dest.validate(fmt.Sprintf("logs[%d]", i), &errs)
+	}
+	for i, s := range c.Containers.Include {
+		s.validate(fmt.Sprintf("containers.include[%d]", i), &errs)
+	}
+	for i, s := range c.Containers.Exclude {
+		s.validate(fmt.Sprintf("containers.exclude[%d]", i), &errs)
+	}
+	for i, m := range c.Multiline {
+		m.validate(fmt.Sprintf("multiline[%d]", i), &errs)
+	}
+	for i, r := range c.Redact {
+		r.validate(fmt.Sprintf("redact[%d]", i), &errs)
+	}
+	for i, r := range c.RateLimits {
+		r.validate(fmt.Sprintf("rate_limits[%d]", i), &errs)
+	}
+	for i, p := range c.Parse {
+		p.validate(fmt.Sprintf("parse[%d]", i), &errs)
+	}
+	ruleNames := map[string]bool{}
+	for i, r := range c.LogRules {
+		key := fmt.Sprintf("log_rules[%d]", i)
+		r.validate(key, &errs)
+		name := r.RuleName(i)
+		if ruleNames[name] {
+			errs.add(key+".name", "duplicate rule %q", name)
+		}
+		ruleNames[name] = true
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateEndpoint(key, endpoint string, errs *Errors) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		errs.add(key, "%s", err)
+	} else if !dockerSchemes[u.Scheme] {
+		errs.add(key, "unsupported endpoint %q, expected unix:///path or tcp://host:port", endpoint)
+	}
+}
+
+func (d *LogDestination) validate(key string, errs *Errors) {
+	if d.Dest == "" {
+		errs.add(key+".dest", "is required")
+	} else if d.Dest != "console" {
+		u, err := url.Parse(d.Dest)
+		if err != nil {
+			errs.add(key+".dest", "%s", err)
+		} else if !logSchemes[u.Scheme] {
+			errs.add(key+".dest", "unsupported destination %q, expected console or [tcp|udp|tls]://host:port", d.Dest)
+		}
+	}
+
+	if d.Format != "" && !logFormats[d.Format] {
+		errs.add(key+".format", "unknown format %q, expected short, ext, json or syslog", d.Format)
+	}
+	if d.Facility != "" {
+		if _, err := logger.Facility(d.Facility); err != nil {
+			errs.add(key+".facility", "unknown facility %q", d.Facility)
+		}
+	}
+	if d.Severity != "" {
+		if _, err := logger.Severity(d.Severity); err != nil {
+			errs.add(key+".severity", "unknown severity %q", d.Severity)
+		}
+	}
+
+	if (d.TLS.Cert == "") != (d.TLS.Key == "") {
+		errs.add(key+".tls", "cert and key must be set together")
+	}
+	if d.TLS != (TLSConfig{}) {
+		if _, err := d.TLS.ClientConfig(); err != nil {
+			errs.add(key+".tls", "%s", err)
+		}
+	}
+
+	for i, pattern := range d.Filter.Containers {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs.add(key+".filter.containers["+strconv.Itoa(i)+"]", "bad pattern %q", pattern)
+		}
+	}
+	for i, stream := range d.Filter.Streams {
+		if !logStreams[stream] {
+			errs.add(key+".filter.streams["+strconv.Itoa(i)+"]", "unknown stream %q, expected stdout or stderr", stream)
+		}
+	}
+
+	if len(d.Routes) > 0 && (len(d.Filter.Containers) > 0 || len(d.Filter.Streams) > 0) {
+		errs.add(key+".filter", "can not be combined with routes")
+	}
+	names := map[string]bool{}
+	for i, r := range d.Routes {
+		routeKey := fmt.Sprintf("%s.routes[%d]", key, i)
+		r.validate(routeKey, errs)
+		name := r.RouteName(i)
+		if names[name] {
+			errs.add(routeKey+".name", "duplicate route %q", name)
+		}
+		names[name] = true
+	}
+}
+
+func (r *Route) validate(key string, errs *Errors) {
+	r.Selector.validate(key, errs)
+	for i, stream := range r.Streams {
+		if !logStreams[stream] {
+			errs.add(key+".streams["+strconv.Itoa(i)+"]", "unknown stream %q, expected stdout or stderr", stream)
+		}
+	}
+	if _, err := regexp.Compile(r.Message); err != nil {
+		errs.add(key+".message", "%s", err)
+	}
+}
+
+// RouteName returns the name of the route at index i of its destination.
+func (r *Route) RouteName(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return strconv.Itoa(i)
+}
+
+func (s *Selector) validate(key string, errs *Errors) {
+	selector := s.DockerSelector()
+	if err := selector.Validate(); err != nil {
+		errs.add(key, "%s", err)
+	}
+}
+
+// DockerSelector returns the selector matching containers.
+func (s *Selector) DockerSelector() docker.Selector {
+	return docker.Selector{
+		Names:  s.Containers,
+		Images: s.Images,
+		Labels: s.Labels,
+	}
+}
+
+// Filter returns the filter selecting containers.
+func (c *ContainersConfig) Filter() *docker.ContainerFilter {
+	filter := &docker.ContainerFilter{}
+	for _, s := range c.Include {
+		filter.Include = append(filter.Include, s.DockerSelector())
+	}
+	for _, s := range c.Exclude {
+		filter.Exclude = append(filter.Exclude, s.DockerSelector())
+	}
+	return filter
+}
+
+func (r *LogRule) validate(key string, errs *Errors) {
+	r.Selector.validate(key, errs)
+	if r.Action != "" && r.Action != "drop" && r.Action != "keep" {
+		errs.add(key+".action", "unknown action %q, expected drop or keep", r.Action)
+	}
+	for i, stream := range r.Streams {
+		if !logStreams[stream] {
+			errs.add(key+".streams["+strconv.Itoa(i)+"]", "unknown stream %q, expected stdout or stderr", stream)
+		}
+	}
+	if r.Match == "" && r.Contains == "" {
+		errs.add(key, "match or contains is required")
+	}
+	if _, err := regexp.Compile(r.Match); err != nil {
+		errs.add(key+".match", "%s", err)
+	}
+}
+
+// RuleName returns the name of the log rule at index i.
+func (r *LogRule) RuleName(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return strconv.Itoa(i)
+}
+
+// Rule returns the log rule at index i of a validated config.
+func (r *LogRule) Rule(i int) *docker.LogRule {
+	rule := &docker.LogRule{
+		Name:     r.RuleName(i),
+		Selector: r.DockerSelector(),
+		Streams:  r.Streams,
+		Contains: r.Contains,
+		Keep:     r.Action == "keep",
+	}
+	if r.Match != "" {
+		rule.Match = regexp.MustCompile(r.Match)
+	}
+	return rule
+}
+
+func (r *Redact) validate(key string, errs *Errors) {
+	r.Selector.validate(key, errs)
+	for i, name := range r.Builtins {
+		if name != "all" && docker.BuiltinRedactRules(name) == nil {
+			errs.add(fmt.Sprintf("%s.builtins[%d]", key, i), "unknown pattern %q, expected %s or all", name, strings.Join(docker.BuiltinRedactions, ", "))
+		}
+	}
+	if len(r.Builtins) == 0 && len(r.Rules) == 0 {
+		errs.add(key, "builtins or rules is required")
+	}
+	for i, rule := range r.Rules {
+		if rule.Pattern == "" {
+			errs.add(fmt.Sprintf("%s.rules[%d].pattern", key, i), "is required")
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			errs.add(fmt.Sprintf("%s.rules[%d].pattern", key, i), "%s", err)
+		}
+	}
+}
+
+// Redaction returns the redaction of a validated config.
+func (r *Redact) Redaction() *docker.Redaction {
+	redaction := &docker.Redaction{Selector: r.DockerSelector()}
+	for _, name := range r.Builtins {
+		if name == "all" {
+			for _, name := range docker.BuiltinRedactions {
+				redaction.Rules = append(redaction.Rules, docker.BuiltinRedactRules(name)...)
+			}
+			continue
+		}
+		redaction.Rules = append(redaction.Rules, docker.BuiltinRedactRules(name)...)
+	}
+	for i, rule := range r.Rules {
+		name, replacement := rule.Name, rule.Replacement
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if replacement == "" {
+			replacement = docker.Redacted
+		}
+		redaction.Rules = append(redaction.Rules, &docker.RedactRule{
+			Name:        name,
+			Pattern:     regexp.MustCompile(rule.Pattern),
+			Replacement: replacement,
+		})
+	}
+	return redaction
+}
+
+func (r *RateLimit) validate(key string, errs *Errors) {
+	r.Selector.validate(key, errs)
+	if r.Rate < 0 {
+		errs.add(key+".rate", "must be positive")
+	}
+	if r.Burst < 0 {
+		errs.add(key+".burst", "must be positive")
+	}
+	if r.Sample < 0 {
+		errs.add(key+".sample", "must be positive")
+	}
+	if r.Rate == 0 && r.Sample <= 1 {
+		errs.add(key, "rate or sample is required")
+	}
+}
+
+// Limit returns the rate limit of a validated config.
+func (r *RateLimit) Limit() *docker.RateLimit {
+	return &docker.RateLimit{
+		Selector: r.DockerSelector(),
+		Rate:     r.Rate,
+		Burst:    r.Burst,
+		Sample:   r.Sample,
+	}
+}
+
+func (p *Parse) validate(key string, errs *Errors) {
+	p.Selector.validate(key, errs)
+	switch p.Format {
+	case "", "auto", "json", "logfmt":
+	default:
+		errs.add(key+".format", "unknown format %q, expected auto, json or logfmt", p.Format)
+	}
+}
+
+// Rule returns the parse rule of a validated config.
+func (p *Parse) Rule() *docker.ParseRule {
+	rule := &docker.ParseRule{
+		Selector: p.DockerSelector(),
+		Format:   p.Format,
+	}
+	if rule.Format == "auto" {
+		rule.Format = ""
+	}
+	return rule
+}
+
+func (m *Multiline) validate(key string, errs *Errors) {
+	m.Selector.validate(key, errs)
+	if m.Start == "" && m.Continue == "" {
+		errs.add(key, "start or continue is required")
+	}
+	if _, err := regexp.Compile(m.Start); err != nil {
+		errs.add(key+".start", "%s", err)
+	}
+	if _, err := regexp.Compile(m.Continue); err != nil {
+		errs.add(key+".continue", "%s", err)
+	}
+	if m.MaxLines < 0 {
+		errs.add(key+".max_lines", "must be positive")
+	}
+	if m.MaxBytes < 0 {
+		errs.add(key+".max_bytes", "must be positive")
+	}
+	if m.Timeout != "" {
+		if _, err := time.ParseDuration(m.Timeout); err != nil {
+			errs.add(key+".timeout", "%s", err)
+		}
+	}
+}
+
+// Rule returns the multiline rule of a validated config.
+func (m *Multiline) Rule() *docker.MultilineRule {
+	rule := &docker.MultilineRule{
+		Selector: m.DockerSelector(),
+		MaxLines: m.MaxLines,
+		MaxBytes: m.MaxBytes,
+	}
+	if m.Start != "" {
+		rule.Start = regexp.MustCompile(m.Start)
+	}
+	if m.Continue != "" {
+		rule.Continue = regexp.MustCompile(m.Continue)
+	}
+	rule.Timeout, _ = time.ParseDuration(m.Timeout)
+	return rule
+}
+
+// ClientConfig returns the TLS client config of a log destination.
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CACert != "" {
+		data, err := ioutil.ReadFile(t.CACert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CACert)
+		}
+	}
+
+	if t.Cert != "" && t.Key != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Flags returns the values of the config keys that have an equivalent
+// command line flag, keyed by flag name.  Unset keys are omitted so they do
+// not override flag defaults.
+func (c *Config) Flags() map[string]string {
+	flags := map[string]string{}
+	setString := func(name, value string) {
+		if value != "" {
+			flags[name] = value
+		}
+	}
+	setInt := func(name string, value int) {
+		if value != 0 {
+			flags[name] = strconv.Itoa(value)
+		}
+	}
+	setBool := func(name string, value bool) {
+		if value {
+			flags[name] = "true"
+		}
+	}
+
+	setString("hostname", c.Hostname)
+	setString("prefix", c.Prefix)
+	setInt("flush-interval", c.FlushInterval)
+	setBool("debug", c.Debug)
+	setBool("no-logs", c.NoLogs)
+	setBool("no-stats", c.NoStats)
+	setString("http-addr", c.HTTPAddr)
+	setString("shutdown-timeout", c.ShutdownTimeout)
+	setString("state-file", c.StateFile)
+	setInt("max-line-size", c.MaxLineSize)
+	setBool("tlsverify", c.Docker.TLSVerify)
+	setString("tlscacert", c.Docker.TLSCACert)
+	setString("tlscert", c.Docker.TLSCert)
+	setString("tlskey", c.Docker.TLSKey)
+
+	m := c.Metrics
+	setBool("flat-names", m.FlatNames)
+	setString("graphite-addr", m.Graphite.Addr)
+	setString("statsd-addr", m.StatsD.Addr)
+	setInt("statsd-mtu", m.StatsD.MTU)
+	setBool("statsd-tags", m.StatsD.Tags)
+	setString("influxdb-addr", m.InfluxDB.URL)
+	setString("influxdb-db", m.InfluxDB.Database)
+	setString("influxdb-rp", m.InfluxDB.RetentionPolicy)
+	setString("influxdb-user", m.InfluxDB.Username)
+	setString("influxdb-pass", m.InfluxDB.Password)
+	setString("influxdb-org", m.InfluxDB.Org)
+	setString("influxdb-bucket", m.InfluxDB.Bucket)
+	setString("influxdb-token", m.InfluxDB.Token)
+	setString("influxdb-precision", m.InfluxDB.Precision)
+	setBool("influxdb-gzip", m.InfluxDB.Gzip)
+	setString("prometheus-addr", m.Prometheus.Addr)
+	setString("spool-dir", m.Spool.Dir)
+	setInt("spool-max-size", m.Spool.MaxSize)
+	return flags
+}
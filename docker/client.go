@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -71,17 +72,34 @@ func parseHost(addr string) (string, string, error) {
 	return proto, fmt.Sprintf("%s:%d", host, port), nil
 }
 
-func GetEndpoint() (string, error) {
+// TLSConfig configures the TLS client of a tcp docker endpoint.  Like the
+// docker client, TLS is used if Verify or Enabled is set, and the daemon
+// certificate is only verified against CACert if Verify is set.  Enabled is
+// set when certificate files are given explicitly, not for the defaults.
+type TLSConfig struct {
+	Verify  bool
+	Enabled bool
+	CACert  string
+	Cert    string
+	Key     string
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.Verify || t.Enabled
+}
+
+// GetEndpoint returns the docker endpoint to connect to: endpoint if set,
+// otherwise DOCKER_HOST or the local unix socket.
+func GetEndpoint(endpoint string) (string, error) {
 	defaultEndpoint := "unix:///var/run/docker.sock"
 	if os.Getenv("DOCKER_HOST") != "" {
 		defaultEndpoint = os.Getenv("DOCKER_HOST")
 	}
 
-	//FIXME: jwilder: add endpoint flag
-	/*	if endpoint != "" {
-			defaultEndpoint = endpoint
-		}
-	*/
+	if endpoint != "" {
+		defaultEndpoint = endpoint
+	}
+
 	_, _, err := parseHost(defaultEndpoint)
 	if err != nil {
 		return "", err
@@ -90,18 +108,17 @@ func GetEndpoint() (string, error) {
 	return defaultEndpoint, nil
 }
 
-func NewDockerClient(endpoint string) (*dockerapi.Client, error) {
-	if strings.HasPrefix(endpoint, "unix:") {
+func NewDockerClient(endpoint string, tlsConfig TLSConfig) (*dockerapi.Client, error) {
+	if strings.HasPrefix(endpoint, "unix:") || !tlsConfig.enabled() {
 		return dockerapi.NewClient(endpoint)
-		//FIXME: jwilder: add tls support
-		/*	} else if tlsVerify || tlsCert != "" || tlsKey != "" || tlsCaCert != "" {
-			if tlsVerify {
-				if tlsCaCert == "" {
-					return nil, errors.New("TLS verification was requested, but no -tlscacert was provided")
-				}
-			}
-
-			return dockerapi.NewTLSClient(endpoint, tlsCert, tlsKey, tlsCaCert)*/
 	}
-	return dockerapi.NewClient(endpoint)
+
+	caCert := ""
+	if tlsConfig.Verify {
+		if tlsConfig.CACert == "" {
+			return nil, errors.New("TLS verification was requested, but no -tlscacert was provided")
+		}
+		caCert = tlsConfig.CACert
+	}
+	return dockerapi.NewTLSClient(endpoint, tlsConfig.Cert, tlsConfig.Key, caCert)
 }
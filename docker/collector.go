@@ -1,9 +1,9 @@
 package docker
 
 import (
+	"context"
 	"fmt"
 	"math"
-	"strings"
 	"sync"
 	"time"
 
@@ -15,6 +15,7 @@ import (
 	"github.com/shirou/gopsutil/mem"
 )
 
+// interval is how long container cpu counters are sampled over.
 const interval = 1 * time.Second
 
 type DockerCollector struct {
@@ -26,7 +27,9 @@ type DockerCollector struct {
 	interval    int
 }
 
-func NewDockerCollector(prefix string, broadcaster *Broadcaster, interval int) *DockerCollector {
+// NewDockerCollector returns a collector that tails container logs with
+// tailer until ctx is cancelled.
+func NewDockerCollector(ctx context.Context, prefix string, broadcaster *Broadcaster, tailer *Tailer, interval int) *DockerCollector {
 
 	collector := &DockerCollector{
 		Broadcaster: broadcaster,
@@ -35,11 +38,9 @@ func NewDockerCollector(prefix string, broadcaster *Broadcaster, interval int) *
 	collector.Prefix = prefix
 	broadcaster.AddEventHandler(collector.onDockerEvent)
 
-	tailer := &Tailer{
-		Broadcaster: broadcaster,
-	}
+	tailer.Broadcaster = broadcaster
 	collector.tailer = tailer
-	go tailer.Tail()
+	tailer.Tail(ctx)
 	tailer.AddLogHandler(collector)
 
 	return collector
@@ -49,13 +50,26 @@ func (d *DockerCollector) AddLogHandler(handler LogHandler) {
 	d.tailer.AddLogHandler(handler)
 }
 
+func (d *DockerCollector) RemoveLogHandler(handler LogHandler) {
+	d.tailer.RemoveLogHandler(handler)
+}
+
+// Containers returns the containers whose logs are tailed.
+func (d *DockerCollector) Containers() []ContainerInfo {
+	return d.tailer.Containers()
+}
+
+// Close detaches from containers and drains the log handlers.
+func (d *DockerCollector) Close(timeout time.Duration) error {
+	return d.tailer.Close(timeout)
+}
+
 func (d *DockerCollector) getDockerClient() (*dockerapi.Client, error) {
 	d.Lock()
 	defer d.Unlock()
 	if d.client == nil {
 		var err error
-		endpoint := d.Broadcaster.Endpoint
-		client, err := NewDockerClient(endpoint)
+		client, err := NewDockerClient(d.Broadcaster.Endpoint, d.Broadcaster.TLS)
 		if err != nil {
 			return nil, fmt.Errorf("unable to connect to docker daemon: %s", err)
 		}
@@ -64,32 +78,46 @@ func (d *DockerCollector) getDockerClient() (*dockerapi.Client, error) {
 	return d.client, nil
 }
 
-func (d *DockerCollector) CollectForever() {
+// CollectForever collects container stats until ctx is cancelled.
+func (d *DockerCollector) CollectForever(ctx context.Context) {
 
-	go d.collectDockerImages()
+	go d.collectDockerImages(ctx)
 
 	var wg sync.WaitGroup
-	for {
+	if !d.Broadcaster.IsLocal() {
+		log.Warnf("Container cpu and memory metrics are not collected for the remote daemon at %s", d.Broadcaster.Endpoint)
+	}
+	for ctx.Err() == nil {
 
 		client, err := d.getDockerClient()
 		if err != nil {
 			log.Errorf("Unable to connect to docker daemon: %s", err)
-			time.Sleep(10 * time.Second)
+			sleep(ctx, 10*time.Second)
 			continue
 		}
 
+		start := time.Now()
 		apiContainers, err := client.ListContainers(dockerapi.ListContainersOptions{
 			All:  false,
 			Size: false,
 		})
+		d.recordAPIDuration("list_containers", start)
 		if err != nil {
 			log.Errorf("Unable to list containers: %s", err)
-			time.Sleep(10 * time.Second)
+			sleep(ctx, 10*time.Second)
 			continue
 		}
 
-		d.RecordGauge("docker.containers", int64(len(apiContainers)))
+		d.RecordGauge("docker.containers", int64(len(apiContainers)), d.Broadcaster.tags()...)
 
+		// container cpu and memory are read from the cgroups of this host
+		if !d.Broadcaster.IsLocal() {
+			sleep(ctx, time.Duration(d.interval)*time.Second)
+			continue
+		}
+		apiContainers = d.measuredContainers(apiContainers)
+
+		start = time.Now()
 		wg.Add(2)
 
 		go func() {
@@ -109,7 +137,14 @@ func (d *DockerCollector) CollectForever() {
 			}
 		}()
 		wg.Wait()
-		time.Sleep(time.Duration(d.interval) * time.Second)
+
+		// leave out the cpu sampling interval
+		elapsed := time.Since(start) - interval
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		metrics.Self.RecordHistogram("hud.collect.duration", nil, elapsed.Seconds(), d.Broadcaster.tags(metrics.PathTag("collector", "docker"))...)
+		sleep(ctx, time.Duration(d.interval)*time.Second)
 	}
 }
 
@@ -130,7 +165,7 @@ func (d *DockerCollector) collectDockerCpu(containers []dockerapi.APIContainers)
 		startTimes[id] = start
 	}
 
-	time.Sleep(1 * time.Second)
+	time.Sleep(interval)
 	hostStop, err := cpu.CPUTimes(false)
 	if err != nil {
 		return err
@@ -187,11 +222,11 @@ func (d *DockerCollector) collectDockerCpu(containers []dockerapi.APIContainers)
 			total = (user + system) / period * numCpus * 100
 		}
 
-		name := d.safeName(container.Names[0][1:])
+		tags := d.containerTags(container)
 
-		d.RecordGaugeFloat64(fmt.Sprintf("docker.cpu.total.%s", name), total)
-		d.RecordGaugeFloat64(fmt.Sprintf("docker.cpu.user.%s", name), userPerc)
-		d.RecordGaugeFloat64(fmt.Sprintf("docker.cpu.system.%s", name), sysPerc)
+		d.RecordGaugeFloat64("docker.cpu.total", total, tags...)
+		d.RecordGaugeFloat64("docker.cpu.user", userPerc, tags...)
+		d.RecordGaugeFloat64("docker.cpu.system", sysPerc, tags...)
 	}
 	return nil
 
@@ -214,60 +249,90 @@ func (d *DockerCollector) collectDockerMemory(containers []dockerapi.APIContaine
 			total = hostVM.Total
 		}
 
-		name := d.safeName(container.Names[0][1:])
-		d.RecordGauge(fmt.Sprintf("docker.mem.total.%s", name),
-			int64(cMem.Cache+cMem.RSS))
-
-		d.RecordGauge(fmt.Sprintf("docker.mem.cache.%s", name),
-			int64(cMem.Cache))
-
-		d.RecordGauge(fmt.Sprintf("docker.mem.rss.%s", name),
-			int64(cMem.RSS))
+		tags := d.containerTags(container)
+		d.RecordGauge("docker.mem.total", int64(cMem.Cache+cMem.RSS), tags...)
+		d.RecordGauge("docker.mem.cache", int64(cMem.Cache), tags...)
+		d.RecordGauge("docker.mem.rss", int64(cMem.RSS), tags...)
 	}
 	return nil
 }
 
-func (d *DockerCollector) collectDockerImages() error {
-	for {
+func (d *DockerCollector) collectDockerImages(ctx context.Context) error {
+	for ctx.Err() == nil {
 
 		client, err := d.getDockerClient()
 		if err != nil {
 			log.Printf("ERROR: Unable to collect docker image stats: %s", err)
-			time.Sleep(10 * time.Second)
+			sleep(ctx, 10*time.Second)
 			continue
 		}
 
+		start := time.Now()
 		images, err := client.ListImages(dockerapi.ListImagesOptions{
 			All: false,
 		})
+		d.recordAPIDuration("list_images", start)
 		if err != nil {
 			return err
 		}
-		d.RecordGauge("docker.images", int64(len(images)))
+		d.RecordGauge("docker.images", int64(len(images)), d.Broadcaster.tags()...)
 
+		start = time.Now()
 		layers, err := client.ListImages(dockerapi.ListImagesOptions{
 			All: true,
 		})
+		d.recordAPIDuration("list_images", start)
 		if err != nil {
 			return err
 		}
 
-		d.RecordGauge("docker.layers", int64(len(layers)))
+		d.RecordGauge("docker.layers", int64(len(layers)), d.Broadcaster.tags()...)
 
-		time.Sleep(60 * time.Second)
+		sleep(ctx, 60*time.Second)
 	}
+	return nil
 }
 
 func (d *DockerCollector) onDockerEvent(client *dockerapi.Client, event *dockerapi.APIEvents) {
-	d.RecordCount(fmt.Sprintf("docker.events.%s", event.Status), 1)
+	d.RecordCount("docker.events", 1, d.Broadcaster.tags(metrics.PathTag("status", event.Status))...)
+}
+
+func (d *DockerCollector) String() string {
+	return "metrics"
 }
 
 func (d *DockerCollector) HandleLog(log *LogRecord) error {
-	d.RecordCount(fmt.Sprintf("docker.logs.total.%s", d.safeName(log.ContainerName)), 1)
-	d.RecordCount(fmt.Sprintf("docker.logs.%s.%s", log.Stream, d.safeName(log.ContainerName)), 1)
+	if !d.tailer.measured(log.ContainerID) {
+		return nil
+	}
+	container := metrics.PathTag("container", log.ContainerName)
+	d.RecordCount("docker.logs.total", 1, d.Broadcaster.tags(container)...)
+	d.RecordCount("docker.logs", 1, d.Broadcaster.tags(metrics.PathTag("stream", log.Stream), container)...)
+	d.RecordHistogram("docker.logs.size", metrics.SizeBuckets, float64(len(log.Message)), d.Broadcaster.tags(container)...)
 	return nil
 }
 
-func (d *DockerCollector) safeName(name string) string {
-	return strings.Replace(name, ".", "_", -1)
+// recordAPIDuration records how long a docker API call took.
+func (d *DockerCollector) recordAPIDuration(call string, start time.Time) {
+	d.RecordHistogram("docker.api.duration", nil, time.Since(start).Seconds(), d.Broadcaster.tags(metrics.NewTag("call", call))...)
+}
+
+// measuredContainers returns the containers selected by the filter of the
+// daemon.
+func (d *DockerCollector) measuredContainers(containers []dockerapi.APIContainers) []dockerapi.APIContainers {
+	measured := []dockerapi.APIContainers{}
+	for _, container := range containers {
+		if d.Broadcaster.Filter.Metrics(container.Names[0][1:], container.Image, container.Labels) {
+			measured = append(measured, container)
+		}
+	}
+	return measured
+}
+
+// containerTags returns the metric tags identifying a container.
+func (d *DockerCollector) containerTags(container dockerapi.APIContainers) metrics.Tags {
+	return d.Broadcaster.tags(
+		metrics.PathTag("container", container.Names[0][1:]),
+		metrics.NewTag("image", container.Image),
+	)
 }
@@ -0,0 +1,210 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// Collectors manages the collectors of several docker daemons.  Log
+// handlers added to Collectors receive the logs of every daemon, one record
+// at a time, and are closed once every daemon has released them.
+type Collectors struct {
+	sync.Mutex
+	collectors []*DockerCollector
+	shared     map[LogHandler]*sharedLogHandler
+}
+
+func NewCollectors(collectors ...*DockerCollector) *Collectors {
+	return &Collectors{
+		collectors: collectors,
+		shared:     map[LogHandler]*sharedLogHandler{},
+	}
+}
+
+func (c *Collectors) AddLogHandler(handler LogHandler) {
+	c.Lock()
+	defer c.Unlock()
+	s := &sharedLogHandler{
+		handler: handler,
+		refs:    len(c.collectors),
+	}
+	c.shared[handler] = s
+	for _, d := range c.collectors {
+		d.AddLogHandler(s)
+	}
+}
+
+// RemoveLogHandler stops sending logs to a handler.  It is closed once the
+// logs queued by every daemon are delivered, or after LogDrainTimeout so a
+// handler stuck reconnecting to an unreachable destination is released.
+func (c *Collectors) RemoveLogHandler(handler LogHandler) {
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.shared[handler]
+	if !ok {
+		return
+	}
+	delete(c.shared, handler)
+	for _, d := range c.collectors {
+		d.RemoveLogHandler(s)
+	}
+	time.AfterFunc(LogDrainTimeout, s.closeHandler)
+}
+
+// WatchForever watches the events of every daemon until ctx is cancelled.
+func (c *Collectors) WatchForever(ctx context.Context) {
+	c.pruneState()
+	c.each(func(d *DockerCollector) {
+		d.Broadcaster.WatchForever(ctx)
+	})
+}
+
+// pruneState forgets the log state of the containers that were removed
+// while hud was not running.  The state is shared by the daemons, so it is
+// only pruned if the containers of every daemon could be listed.
+func (c *Collectors) pruneState() {
+	ids := map[string]bool{}
+	states := map[*LogState]bool{}
+	for _, d := range c.collectors {
+		client, err := d.getDockerClient()
+		if err != nil {
+			log.Debugf("Not pruning the log state: %s", err)
+			return
+		}
+		containers, err := client.ListContainers(dockerapi.ListContainersOptions{All: true})
+		if err != nil {
+			log.Debugf("Not pruning the log state: %s", err)
+			return
+		}
+		for _, container := range containers {
+			ids[container.ID] = true
+		}
+		if d.tailer.State != nil {
+			states[d.tailer.State] = true
+		}
+	}
+	for state := range states {
+		state.Prune(ids)
+	}
+}
+
+// CollectForever collects the container stats of every daemon until ctx is
+// cancelled.
+func (c *Collectors) CollectForever(ctx context.Context) {
+	c.each(func(d *DockerCollector) {
+		d.CollectForever(ctx)
+	})
+}
+
+// Close detaches from the containers of every daemon and drains the log
+// handlers.
+func (c *Collectors) Close(timeout time.Duration) error {
+	errs := make(chan error, len(c.collectors))
+	c.each(func(d *DockerCollector) {
+		if err := d.Close(timeout); err != nil {
+			errs <- fmt.Errorf("%s: %s", d.Broadcaster.Endpoint, err)
+		}
+	})
+	close(errs)
+	err := <-errs
+
+	// release the handlers that could not deliver their logs in time
+	if err != nil {
+		c.Lock()
+		for _, s := range c.shared {
+			s.closeHandler()
+		}
+		c.Unlock()
+	}
+	return err
+}
+
+// Containers returns the containers whose logs are tailed, sorted by daemon
+// and name.
+func (c *Collectors) Containers() []ContainerInfo {
+	containers := []ContainerInfo{}
+	for _, d := range c.collectors {
+		containers = append(containers, d.Containers()...)
+	}
+	sort.Sort(byName(containers))
+	return containers
+}
+
+// RecentEvents returns the last events of every daemon, oldest first.
+func (c *Collectors) RecentEvents() []Event {
+	events := []Event{}
+	for _, d := range c.collectors {
+		events = append(events, d.Broadcaster.RecentEvents()...)
+	}
+	sort.Stable(byTime(events))
+	return events
+}
+
+// each runs fn for every collector concurrently and waits for them.
+func (c *Collectors) each(fn func(d *DockerCollector)) {
+	var wg sync.WaitGroup
+	for _, d := range c.collectors {
+		wg.Add(1)
+		go func(d *DockerCollector) {
+			defer wg.Done()
+			fn(d)
+		}(d)
+	}
+	wg.Wait()
+}
+
+type byTime []Event
+
+func (e byTime) Len() int           { return len(e) }
+func (e byTime) Less(i, j int) bool { return e[i].Time.Before(e[j].Time) }
+func (e byTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+// sharedLogHandler lets the tailers of several daemons deliver to one log
+// handler.
+type sharedLogHandler struct {
+	sync.Mutex
+	handler LogHandler
+	refs    int
+	closing sync.Once
+}
+
+func (s *sharedLogHandler) String() string {
+	return handlerName(s.handler)
+}
+
+func (s *sharedLogHandler) HandleLog(log *LogRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.handler.HandleLog(log)
+}
+
+// Close closes the handler when the last tailer releases it.
+func (s *sharedLogHandler) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	s.closeHandler()
+	return nil
+}
+
+// closeHandler closes the handler once.  It does not wait for a log being
+// handled so that it can interrupt a handler that is blocked.
+func (s *sharedLogHandler) closeHandler() {
+	s.closing.Do(func() {
+		if c, ok := s.handler.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Errorf("ERROR: Unable to close log handler %s: %s", s, err)
+			}
+		}
+	})
+}
@@ -1,74 +1,130 @@
 package docker
 
 import (
+	"context"
+	"net"
 	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/jwilder/hud/metrics"
 )
 
 const DefaultReconnectTimeout = 1 * time.Second
 
+// maxEvents is the number of recent events kept by a Broadcaster.
+const maxEvents = 100
+
 type PreWatch func(client *dockerapi.Client)
 type EventHandler func(client *dockerapi.Client, event *dockerapi.APIEvents)
 
+// Broadcaster handlers are identified by the id returned when they are
+// added since funcs can not be compared.  When hud watches several daemons,
+// Daemon tags the logs and metrics of each one.  Filter selects the
+// containers of the daemon that are tailed and measured.
 type Broadcaster struct {
 	sync.Mutex
 	Endpoint         string
-	eventHandlers    []EventHandler
-	preWatchHandlers []PreWatch
+	TLS              TLSConfig
+	Daemon           metrics.Tag
+	Filter           *ContainerFilter
+	nextID           int
+	eventHandlers    map[int]EventHandler
+	preWatchHandlers map[int]PreWatch
+	events           []Event
 }
 
-func (b *Broadcaster) AddEventHandler(fn EventHandler) {
-	b.Lock()
-	defer b.Unlock()
-	b.eventHandlers = append(b.eventHandlers, fn)
+// Event is a docker event seen by a Broadcaster.
+type Event struct {
+	Daemon string    `json:"daemon,omitempty"`
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	ID     string    `json:"id"`
+	From   string    `json:"from,omitempty"`
 }
 
-func (b *Broadcaster) RemoveEventHandler(fn EventHandler) {
-	b.Lock()
-	defer b.Unlock()
+// tags appends the daemon tag, if any, to the tags of a metric.
+func (b *Broadcaster) tags(tags ...metrics.Tag) metrics.Tags {
+	if b.Daemon.Key == "" {
+		return tags
+	}
+	return append(tags, b.Daemon)
+}
 
-	eventHandlers := []EventHandler{}
-	for _, l := range b.eventHandlers {
-		if &l == &fn {
-			continue
-		}
-		eventHandlers = append(eventHandlers, l)
+// IsLocal returns true if the daemon runs on this host.
+func (b *Broadcaster) IsLocal() bool {
+	proto, addr, err := parseHost(b.Endpoint)
+	if err != nil {
+		return false
 	}
-	b.eventHandlers = eventHandlers
+	host, _, _ := net.SplitHostPort(addr)
+	return proto == "unix" || host == "localhost" || host == "127.0.0.1"
 }
 
-func (b *Broadcaster) AddPreWatchHandler(fn PreWatch) {
+func (b *Broadcaster) AddEventHandler(fn EventHandler) int {
 	b.Lock()
 	defer b.Unlock()
-	b.preWatchHandlers = append(b.preWatchHandlers, fn)
+	if b.eventHandlers == nil {
+		b.eventHandlers = map[int]EventHandler{}
+	}
+	b.nextID++
+	b.eventHandlers[b.nextID] = fn
+	return b.nextID
 }
 
-func (b *Broadcaster) RemovePreWatchHandler(fn PreWatch) {
+func (b *Broadcaster) RemoveEventHandler(id int) {
 	b.Lock()
 	defer b.Unlock()
+	delete(b.eventHandlers, id)
+}
 
-	handlers := []PreWatch{}
-	for _, h := range b.preWatchHandlers {
-		if &h == &fn {
-			continue
-		}
-		handlers = append(handlers, h)
+func (b *Broadcaster) AddPreWatchHandler(fn PreWatch) int {
+	b.Lock()
+	defer b.Unlock()
+	if b.preWatchHandlers == nil {
+		b.preWatchHandlers = map[int]PreWatch{}
 	}
-	b.preWatchHandlers = handlers
+	b.nextID++
+	b.preWatchHandlers[b.nextID] = fn
+	return b.nextID
+}
+
+func (b *Broadcaster) RemovePreWatchHandler(id int) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.preWatchHandlers, id)
 }
 
 func (b *Broadcaster) broadcast(client *dockerapi.Client, event *dockerapi.APIEvents) {
+	metrics.Self.RecordCount("hud.docker.events", 1, b.tags()...)
+
 	b.Lock()
 	defer b.Unlock()
+	b.events = append(b.events, Event{
+		Daemon: b.Daemon.Value,
+		Time:   time.Unix(event.Time, 0),
+		Status: event.Status,
+		ID:     event.ID,
+		From:   event.From,
+	})
+	if len(b.events) > maxEvents {
+		b.events = append([]Event{}, b.events[len(b.events)-maxEvents:]...)
+	}
+
 	for _, fn := range b.eventHandlers {
 		// make sure writing on the channel does not block
 		go fn(client, event)
 	}
 }
 
+// RecentEvents returns the last events seen, oldest first.
+func (b *Broadcaster) RecentEvents() []Event {
+	b.Lock()
+	defer b.Unlock()
+	return append([]Event{}, b.events...)
+}
+
 func (b *Broadcaster) notifyPreWatch(client *dockerapi.Client) {
 	b.Lock()
 	defer b.Unlock()
@@ -77,16 +133,25 @@ func (b *Broadcaster) notifyPreWatch(client *dockerapi.Client) {
 		go fn(client)
 	}
 }
-func (b *Broadcaster) WatchForever() {
+
+// WatchForever watches docker events and notifies the handlers until ctx is
+// cancelled.
+func (b *Broadcaster) WatchForever(ctx context.Context) {
 	var client *dockerapi.Client
 
-	for {
+	connected := false
+	for ctx.Err() == nil {
 		if client == nil {
+			if connected {
+				metrics.Self.RecordCount("hud.reconnects", 1, b.tags(metrics.PathTag("component", "docker"))...)
+			}
+			connected = true
+
 			var err error
-			client, err = NewDockerClient(b.Endpoint)
+			client, err = NewDockerClient(b.Endpoint, b.TLS)
 			if err != nil {
 				log.Errorf("Unable to connect to docker daemon: %s", err)
-				time.Sleep(DefaultReconnectTimeout)
+				sleep(ctx, DefaultReconnectTimeout)
 				continue
 			}
 		}
@@ -108,7 +173,7 @@ func (b *Broadcaster) WatchForever() {
 					watching = false
 					client = nil
 				}
-				time.Sleep(DefaultReconnectTimeout)
+				sleep(ctx, DefaultReconnectTimeout)
 				break
 
 			}
@@ -118,7 +183,9 @@ func (b *Broadcaster) WatchForever() {
 				err = client.AddEventListener(eventChan)
 				if err != nil && err != dockerapi.ErrListenerAlreadyExists {
 					log.Errorf("Error registering docker event listener: %s", err)
-					time.Sleep(DefaultReconnectTimeout)
+					if !sleep(ctx, DefaultReconnectTimeout) {
+						return
+					}
 					continue
 				}
 				watching = true
@@ -140,8 +207,40 @@ func (b *Broadcaster) WatchForever() {
 				b.broadcast(client, event)
 			case <-time.After(10 * time.Second):
 				// check for docker liveness
+			case <-ctx.Done():
+				if watching {
+					client.RemoveEventListener(eventChan)
+				}
+				log.Debug("Stopped watching docker events")
+				return
 			}
 
 		}
 	}
 }
+
+// sleep waits for d and returns false if ctx was cancelled meanwhile.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waitTimeout waits for wg and returns false if it took longer than d.
+func waitTimeout(wg *sync.WaitGroup, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"strconv"
+)
+
+const (
+	// LogsLabel set to false on a container stops hud tailing its logs.
+	LogsLabel = "hud.logs"
+	// MetricsLabel set to false on a container stops hud collecting its
+	// metrics.
+	MetricsLabel = "hud.metrics"
+)
+
+// ContainerFilter selects the containers hud tails and measures.  A
+// container is selected if it matches one of the Include selectors, or
+// there are none, and none of the Exclude selectors.  Containers can also
+// opt out with the hud.logs=false and hud.metrics=false labels.  A nil
+// filter selects every container that did not opt out.
+type ContainerFilter struct {
+	Include []Selector
+	Exclude []Selector
+}
+
+// Logs returns true if the logs of a container are tailed.
+func (f *ContainerFilter) Logs(name, image string, labels map[string]string) bool {
+	return f.selects(name, image, labels, LogsLabel)
+}
+
+// Metrics returns true if the metrics of a container are collected.
+func (f *ContainerFilter) Metrics(name, image string, labels map[string]string) bool {
+	return f.selects(name, image, labels, MetricsLabel)
+}
+
+func (f *ContainerFilter) selects(name, image string, labels map[string]string, label string) bool {
+	if enabled, err := strconv.ParseBool(labels[label]); err == nil && !enabled {
+		return false
+	}
+	if f == nil {
+		return true
+	}
+
+	included := len(f.Include) == 0
+	for _, s := range f.Include {
+		if s.MatchNames(name, image, labels) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, s := range f.Exclude {
+		if s.MatchNames(name, image, labels) {
+			return false
+		}
+	}
+	return true
+}
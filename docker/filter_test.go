@@ -0,0 +1,44 @@
+package docker
+
+import (
+	"testing"
+)
+
+func TestContainerFilter(t *testing.T) {
+	filter := &ContainerFilter{
+		Include: []Selector{
+			{Names: []string{"web-*"}},
+			{Labels: map[string]string{"team": "payments"}},
+		},
+		Exclude: []Selector{
+			{Images: []string{"*/sidecar*"}},
+		},
+	}
+
+	tests := []struct {
+		name, image   string
+		labels        map[string]string
+		logs, metrics bool
+	}{
+		{"web-1", "nginx", nil, true, true},
+		{"db-1", "postgres", nil, false, false},
+		{"api-1", "api", map[string]string{"team": "payments"}, true, true},
+		{"web-2", "acme/sidecar:1", nil, false, false},
+		{"web-3", "nginx", map[string]string{LogsLabel: "false"}, false, true},
+		{"web-4", "nginx", map[string]string{MetricsLabel: "false"}, true, false},
+		{"web-5", "nginx", map[string]string{LogsLabel: "true"}, true, true},
+	}
+	for _, test := range tests {
+		if got := filter.Logs(test.name, test.image, test.labels); got != test.logs {
+			t.Errorf("%s: expected logs %v, got %v", test.name, test.logs, got)
+		}
+		if got := filter.Metrics(test.name, test.image, test.labels); got != test.metrics {
+			t.Errorf("%s: expected metrics %v, got %v", test.name, test.metrics, got)
+		}
+	}
+
+	var none *ContainerFilter
+	if !none.Logs("db-1", "postgres", nil) || none.Metrics("db-1", "postgres", map[string]string{MetricsLabel: "false"}) {
+		t.Errorf("expected a nil filter to only apply labels")
+	}
+}
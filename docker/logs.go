@@ -2,13 +2,37 @@ package docker
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/Sirupsen/logrus"
 	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/jwilder/hud/metrics"
+)
+
+const (
+	// DefaultMaxLineSize is the size of the longest log message sent before
+	// it is truncated.
+	DefaultMaxLineSize = 256 * 1024
+
+	// partialSize is the size of the chunks the daemon splits long log
+	// messages into.
+	partialSize = 16 * 1024
+
+	// LogQueueSize is the number of log records queued for each log
+	// handler.  Records are dropped when a handler falls this far behind.
+	LogQueueSize = 1000
+
+	// LogDrainTimeout is how long a removed log handler is given to deliver
+	// its queued records before it is closed.
+	LogDrainTimeout = 30 * time.Second
 )
 
 type LogChannel chan *LogRecord
@@ -16,77 +40,236 @@ type LogChannel chan *LogRecord
 type Tailer struct {
 	sync.Mutex
 	Broadcaster *Broadcaster
-	watchers    map[string]bool
+	watchers    map[string]*ContainerInfo
+	unmeasured  map[string]bool
 	Running     bool
-	logHandlers []LogChannel
+	State       *LogState
+	Multiline   []*MultilineRule
+	Rules       []*LogRule
+	Redactions  []*Redaction
+	RateLimits  []*RateLimit
+	Parse       []*ParseRule
+	MaxLineSize int
+	logHandlers map[LogHandler]LogChannel
+	ctx         context.Context
+
+	limitLock sync.Mutex
+	limiters  map[string]*limiter
+
+	// tailing tracks the goroutines that read container logs and handling
+	// tracks the goroutines that pass them to the log handlers.
+	tailing  sync.WaitGroup
+	handling sync.WaitGroup
 }
 
+// LogRecord is a line logged by a container.  Ts is when the daemon
+// received the line and Received is when hud read it.  Truncated is set if
+// the end of the line was dropped because it was too long.  Labels are the
+// labels of the container and must not be modified.  Level and Fields are
+// set if the line is parsed as structured data.
 type LogRecord struct {
 	Ts            time.Time
+	Received      time.Time
+	Daemon        string
 	ContainerID   string
 	ContainerName string
+	Image         string
+	Labels        map[string]string
 	Stream        string
 	Message       string
+	Truncated     bool
+	Level         string
+	Fields        map[string]interface{}
+
+	// checkpoint is the daemon timestamp of the last line of the record.
+	// It is zero for records that were not read from a container.
+	checkpoint time.Time
+}
+
+// ContainerInfo describes a container the Tailer is attached to.
+type ContainerInfo struct {
+	Daemon     string           `json:"daemon,omitempty"`
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	Streams    []string         `json:"streams"`
+	AttachedAt time.Time        `json:"attached_at"`
+	Lines      map[string]int64 `json:"lines"`
 }
 
 type LogHandler interface {
 	HandleLog(log *LogRecord) error
 }
 
-func (t *Tailer) watchContainer(client *dockerapi.Client, id string, all bool) error {
+// watchContainer tails the logs of a container unless it is already tailed
+// or the filter of the daemon excludes it.
+func (t *Tailer) watchContainer(client *dockerapi.Client, id string, fromStart bool) error {
 	t.Lock()
-	defer t.Unlock()
+	_, ok := t.watchers[id]
+	t.Unlock()
+	if ok || t.ctx.Err() != nil {
+		return nil
+	}
+
+	container, err := client.InspectContainer(id)
+	if err != nil {
+		metrics.Self.RecordCount("hud.logs.attach.errors", 1, t.Broadcaster.tags()...)
+		return fmt.Errorf("tailing: %s", err)
+	}
+	name := strings.TrimPrefix(container.Name, "/")
+	filter := t.Broadcaster.Filter
 
-	if _, ok := t.watchers[id]; ok {
+	t.Lock()
+	defer t.Unlock()
+	if !filter.Metrics(name, container.Config.Image, container.Config.Labels) {
+		t.unmeasured[id] = true
+	}
+	if !filter.Logs(name, container.Config.Image, container.Config.Labels) {
+		log.Debugf("Not tailing excluded container %s", name)
+		return nil
+	}
+	if _, ok := t.watchers[id]; ok || t.ctx.Err() != nil {
 		return nil
 	}
-	t.watchers[id] = true
+	t.watchers[id] = &ContainerInfo{Daemon: t.Broadcaster.Daemon.Value, ID: id, Lines: map[string]int64{}}
+	metrics.Self.RecordGauge("hud.logs.attached", int64(len(t.watchers)), t.Broadcaster.tags()...)
 
+	t.tailing.Add(1)
 	go func() {
-		t.tailContainer(client, id, all)
+		defer t.tailing.Done()
+		t.tailContainer(client, container, fromStart)
+		t.summarize(id, true)
 		t.Lock()
 		defer t.Unlock()
 		delete(t.watchers, id)
+		metrics.Self.RecordGauge("hud.logs.attached", int64(len(t.watchers)), t.Broadcaster.tags()...)
 	}()
-	//t.watchers[id] = watcher
 	return nil
 }
 
+// measured returns true if the metrics of a container are collected.
+func (t *Tailer) measured(id string) bool {
+	t.Lock()
+	defer t.Unlock()
+	return !t.unmeasured[id]
+}
+
 func (t *Tailer) AddLogHandler(h LogHandler) {
 	t.Lock()
 	defer t.Unlock()
-	logChan := make(LogChannel, 100)
-	t.logHandlers = append(t.logHandlers, logChan)
+	if t.logHandlers == nil {
+		t.logHandlers = map[LogHandler]LogChannel{}
+	}
+	logChan := make(LogChannel, LogQueueSize)
+	t.logHandlers[h] = logChan
+	t.handling.Add(1)
 	go t.handleLogs(logChan, h)
 }
 
-func (t *Tailer) RemoveLogHandler(c LogChannel) {
+// RemoveLogHandler stops sending logs to a handler.  Logs already queued for
+// the handler are still delivered, then it is closed if it is an io.Closer.
+func (t *Tailer) RemoveLogHandler(h LogHandler) {
 	t.Lock()
 	defer t.Unlock()
+	if logChan, ok := t.logHandlers[h]; ok {
+		close(logChan)
+		delete(t.logHandlers, h)
+	}
+}
 
-	logHandlers := []LogChannel{}
-	for _, l := range t.logHandlers {
-		if &l == &c {
-			continue
+func (t *Tailer) notifyLog(msg *LogRecord) {
+	defer t.checkpoint(msg)
+	metrics.Self.RecordCount("hud.logs.received", 1, metrics.PathTag("stream", msg.Stream))
+	if !t.keep(msg) || !t.allow(msg) {
+		return
+	}
+	t.parse(msg)
+	t.redact(msg)
+	t.fanOut(msg)
+}
+
+// checkpoint records that a log record was handled so that tailing resumes
+// after it.
+func (t *Tailer) checkpoint(msg *LogRecord) {
+	if t.State != nil && !msg.checkpoint.IsZero() {
+		t.State.Seen(msg.ContainerID, msg.Stream, msg.checkpoint)
+	}
+}
+
+// fanOut queues a log record for every log handler.  A handler whose queue
+// is full misses the record rather than holding up the other handlers and
+// containers.
+func (t *Tailer) fanOut(msg *LogRecord) {
+	t.Lock()
+	defer t.Unlock()
+	if w, ok := t.watchers[msg.ContainerID]; ok {
+		w.Lines[msg.Stream]++
+	}
+	for h, c := range t.logHandlers {
+		select {
+		case c <- msg:
+		default:
+			metrics.Self.RecordCount("hud.logs.dropped", 1,
+				metrics.PathTag("destination", handlerName(h)),
+				metrics.NewTag("reason", "queue_full"))
 		}
-		logHandlers = append(logHandlers, l)
 	}
-	t.logHandlers = logHandlers
 }
 
-func (t *Tailer) notifyLog(msg *LogRecord) {
+// Containers returns the containers the tailer is attached to, sorted by
+// daemon and name.
+func (t *Tailer) Containers() []ContainerInfo {
 	t.Lock()
 	defer t.Unlock()
-	for _, c := range t.logHandlers {
-		c <- msg
+	containers := []ContainerInfo{}
+	for _, w := range t.watchers {
+		if w.AttachedAt.IsZero() {
+			continue
+		}
+		info := *w
+		info.Lines = map[string]int64{}
+		for stream, n := range w.Lines {
+			info.Lines[stream] = n
+		}
+		containers = append(containers, info)
+	}
+	sort.Sort(byName(containers))
+	return containers
+}
+
+type byName []ContainerInfo
+
+func (c byName) Len() int { return len(c) }
+func (c byName) Less(i, j int) bool {
+	if c[i].Daemon != c[j].Daemon {
+		return c[i].Daemon < c[j].Daemon
 	}
+	return c[i].Name < c[j].Name
 }
+func (c byName) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
 
 func (t *Tailer) handleLogs(logs LogChannel, handler LogHandler) {
-	for {
-		log := <-logs
-		handler.HandleLog(log)
+	defer t.handling.Done()
+	dest := metrics.PathTag("destination", handlerName(handler))
+	for log := range logs {
+		if err := handler.HandleLog(log); err != nil {
+			metrics.Self.RecordCount("hud.logs.dropped", 1, dest, metrics.NewTag("reason", "error"))
+			continue
+		}
+		metrics.Self.RecordCount("hud.logs.forwarded", 1, dest)
+	}
+
+	if c, ok := handler.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// handlerName returns the name of a log handler used to tag its metrics.
+// Handlers can implement fmt.Stringer to name themselves.
+func handlerName(handler LogHandler) string {
+	if s, ok := handler.(fmt.Stringer); ok {
+		return s.String()
 	}
+	return fmt.Sprintf("%T", handler)
 }
 
 func (t *Tailer) onWatch(client *dockerapi.Client) {
@@ -116,102 +299,299 @@ func (t *Tailer) onEvent(client *dockerapi.Client, event *dockerapi.APIEvents) {
 	}
 
 	if event.Status == "start" {
-		err := t.watchContainer(client, event.ID, false)
+		err := t.watchContainer(client, event.ID, true)
 		if err != nil {
 			log.Errorf("ERROR: %s", err)
 		}
 	}
+
+	if event.Status == "destroy" {
+		t.State.Remove(event.ID)
+		t.Lock()
+		delete(t.unmeasured, event.ID)
+		t.Unlock()
+	}
 }
 
-func (t *Tailer) Tail() {
-	t.watchers = map[string]bool{}
+// Tail attaches to running and new containers until ctx is cancelled.  If
+// the tailer has no State, the last lines seen are only kept in memory.
+func (t *Tailer) Tail(ctx context.Context) {
+	if t.State == nil {
+		t.State, _ = NewLogState("")
+	}
+	t.ctx = ctx
+	t.watchers = map[string]*ContainerInfo{}
+	t.unmeasured = map[string]bool{}
 	t.Broadcaster.AddPreWatchHandler(t.onWatch)
 	t.Broadcaster.AddEventHandler(t.onEvent)
+
+	t.tailing.Add(1)
+	go func() {
+		defer t.tailing.Done()
+		t.summarizeForever(ctx)
+	}()
 }
 
-func (t *Tailer) tailContainer(client *dockerapi.Client, id string, all bool) {
-	container, err := client.InspectContainer(id)
-	if err != nil {
-		log.Errorf("ERROR: tailing: %s\n", err)
-		return
+// tailContainer follows the logs of a container until it stops.
+func (t *Tailer) tailContainer(client *dockerapi.Client, container *dockerapi.Container, fromStart bool) {
+	opts := dockerapi.LogsOptions{
+		Container:   container.ID,
+		Follow:      true,
+		Stdout:      true,
+		Stderr:      true,
+		Timestamps:  true,
+		RawTerminal: container.Config.Tty,
 	}
+	t.setLogsStart(&opts, container, fromStart)
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	success := make(chan struct{})
 	stdoutReader, stdoutWriter := io.Pipe()
 	stderrReader, stderrWriter := io.Pipe()
+	opts.OutputStream = stdoutWriter
+	opts.ErrorStream = stderrWriter
 
-	go t.WriteLogs(&NamedReader{
-		Name:   strings.TrimPrefix(container.Name, "/"),
-		ID:     container.ID,
-		Reader: stdoutReader,
-		Stream: "stdout"})
-	go t.WriteLogs(&NamedReader{
-		Name:   strings.TrimPrefix(container.Name, "/"),
-		ID:     container.ID,
-		Reader: stderrReader,
-		Stream: "stderr"})
+	rule := t.multilineRule(container)
+	t.tailing.Add(2)
+	go func() {
+		defer t.tailing.Done()
+		t.WriteLogs(&NamedReader{
+			Name:      strings.TrimPrefix(container.Name, "/"),
+			ID:        container.ID,
+			Image:     container.Config.Image,
+			Labels:    container.Config.Labels,
+			Reader:    stdoutReader,
+			Stream:    "stdout",
+			Multiline: rule})
+	}()
+	go func() {
+		defer t.tailing.Done()
+		t.WriteLogs(&NamedReader{
+			Name:      strings.TrimPrefix(container.Name, "/"),
+			ID:        container.ID,
+			Image:     container.Config.Image,
+			Labels:    container.Config.Labels,
+			Reader:    stderrReader,
+			Stream:    "stderr",
+			Multiline: rule})
+	}()
 
+	// detach on shutdown by failing the writes of the log stream
+	detached := make(chan struct{})
+	defer close(detached)
 	go func() {
-		log.Debugf("Attached to container %s", container.ID[0:12])
-		defer wg.Done()
-		err = client.AttachToContainer(dockerapi.AttachToContainerOptions{
-			Container:    container.ID,
-			OutputStream: stdoutWriter,
-			ErrorStream:  stderrWriter,
-			Logs:         all,
-			Stdin:        false,
-			Stdout:       true,
-			Stderr:       true,
-			Stream:       true,
-			Success:      success,
-			RawTerminal:  container.Config.Tty,
-		})
-		if err != nil {
-			close(success)
-			log.Errorf("ERROR: Unable to attach to container: %s\n", err)
-			return
+		select {
+		case <-t.ctx.Done():
+			stdoutWriter.CloseWithError(t.ctx.Err())
+			stderrWriter.CloseWithError(t.ctx.Err())
+		case <-detached:
 		}
 	}()
 
-	_, ok := <-success
-	if ok {
-		success <- struct{}{}
+	t.attached(container)
+	log.Debugf("Attached to container %s", container.ID[0:12])
+	err := client.Logs(opts)
+	if err != nil && t.ctx.Err() == nil {
+		metrics.Self.RecordCount("hud.logs.attach.errors", 1, t.Broadcaster.tags()...)
+		log.Errorf("ERROR: Unable to follow container logs: %s\n", err)
 	}
 
-	wg.Wait()
 	stdoutWriter.Close()
 	stderrWriter.Close()
 	log.Debugf("Detached from container %s", container.ID[0:12])
 }
 
+// setLogsStart sets where to read the logs of a container from: the last
+// line seen if the container was tailed before, otherwise from when it
+// started if fromStart is set, otherwise from now.  A created container
+// that has not started yet has no start time and no backlog to skip, so it
+// is read from its first line.
+func (t *Tailer) setLogsStart(opts *dockerapi.LogsOptions, container *dockerapi.Container, fromStart bool) {
+	if since, ok := t.State.Since(container.ID); ok {
+		opts.Since = since.Unix()
+	} else if !fromStart {
+		opts.Tail = "0"
+	} else if !container.State.StartedAt.IsZero() {
+		opts.Since = container.State.StartedAt.Unix()
+	}
+}
+
+// attached records that the tailer is attached to a container.
+func (t *Tailer) attached(container *dockerapi.Container) {
+	t.Lock()
+	defer t.Unlock()
+	w, ok := t.watchers[container.ID]
+	if !ok {
+		return
+	}
+	w.Name = strings.TrimPrefix(container.Name, "/")
+	w.Streams = []string{"stdout", "stderr"}
+	if container.Config.Tty {
+		w.Streams = []string{"stdout"}
+	}
+	w.AttachedAt = time.Now()
+}
+
+// Close waits for the tailer to detach from containers, then delivers the
+// queued logs to the log handlers and closes them.  It gives up after
+// timeout.
+func (t *Tailer) Close(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if !waitTimeout(&t.tailing, timeout) {
+		return fmt.Errorf("timed out detaching from containers")
+	}
+
+	t.Lock()
+	for h, logChan := range t.logHandlers {
+		close(logChan)
+		delete(t.logHandlers, h)
+	}
+	t.Unlock()
+
+	if !waitTimeout(&t.handling, deadline.Sub(time.Now())) {
+		return fmt.Errorf("timed out draining log handlers")
+	}
+	return nil
+}
+
+// WriteLogs sends the lines read from input to the log handlers, joining the
+// lines of multiline events if input has a multiline rule.  Lines longer
+// than MaxLineSize are truncated and a last line without a newline is sent
+// when input ends.
 func (w *Tailer) WriteLogs(input *NamedReader) {
-	buf := bufio.NewReaderSize(input, 4096*16)
+	send := w.notifyLog
+	if input.Multiline != nil {
+		m := newMultiline(input.Multiline, w.notifyLog)
+		defer m.Flush()
+		send = m.Add
+	}
+
+	max := w.MaxLineSize
+	if max <= 0 {
+		max = DefaultMaxLineSize
+	}
+	// leave room for the timestamps of the chunks of long messages
+	limit := max + (max/partialSize+1)*(len(time.RFC3339Nano)+1)
+
+	buf := bufio.NewReaderSize(input, partialSize*4)
 	for {
-		data, err := buf.ReadBytes('\n')
-		if err != nil {
-			if err != io.EOF {
-				println(err)
+		data, truncated, err := readLine(buf, limit)
+		if err != nil && err != io.EOF {
+			if w.ctx.Err() == nil {
+				log.Errorf("ERROR: Unable to read %s of %s: %s", input.Stream, input.Name, err)
 			}
 			return
 		}
+		if len(data) == 0 {
+			return
+		}
+
+		received := time.Now()
+		ts, data := splitTimestamp(data)
+		checkpoint := ts
+		if ts.IsZero() {
+			ts = received
+		} else if w.State.Handled(input.ID, input.Stream, ts) {
+			metrics.Self.RecordCount("hud.logs.duplicates", 1, w.Broadcaster.tags()...)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		data = joinPartials(data)
+		if len(bytes.TrimSuffix(data, []byte("\n"))) > max {
+			truncated = true
+		}
+		if truncated {
+			data = append(truncate(data, max), '\n')
+			metrics.Self.RecordCount("hud.logs.truncated", 1, w.Broadcaster.tags()...)
+		}
 
-		w.notifyLog(&LogRecord{
-			Ts:            time.Now(),
+		send(&LogRecord{
+			Ts:            ts,
+			Received:      received,
+			Daemon:        w.Broadcaster.Daemon.Value,
 			ContainerID:   input.ID,
 			ContainerName: input.Name,
+			Image:         input.Image,
+			Labels:        input.Labels,
 			Stream:        input.Stream,
 			Message:       string(data),
+			Truncated:     truncated,
+			checkpoint:    checkpoint,
 		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readLine reads a line of at most max bytes.  The rest of a longer line is
+// discarded and truncated is set.  If input ends before a newline, the
+// partial line is returned with io.EOF.
+func readLine(buf *bufio.Reader, max int) (line []byte, truncated bool, err error) {
+	for {
+		data, err := buf.ReadSlice('\n')
+		if n := max - len(line); len(data) > n {
+			line = append(line, data[:n]...)
+			truncated = true
+		} else {
+			line = append(line, data...)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, truncated, err
+		}
+	}
+}
+
+// joinPartials removes the timestamps the daemon prefixes each chunk of a
+// long log message with, joining the message back together.
+func joinPartials(data []byte) []byte {
+	for i := partialSize; i < len(data); i += partialSize {
+		ts, rest := splitTimestamp(data[i:])
+		if ts.IsZero() {
+			break
+		}
+		data = append(data[:i], rest...)
+	}
+	return data
+}
+
+// truncate returns the first max bytes of a line without its newline,
+// without splitting a UTF-8 character.
+func truncate(data []byte, max int) []byte {
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	if len(data) <= max {
+		return data
+	}
+	for max > 0 && !utf8.RuneStart(data[max]) {
+		max--
+	}
+	return data[:max]
+}
+
+// splitTimestamp splits the timestamp the daemon prefixes log lines with
+// from the line.  If the line has no timestamp, it is returned unchanged
+// with the zero time.
+func splitTimestamp(data []byte) (time.Time, []byte) {
+	i := bytes.IndexByte(data, ' ')
+	if i < 0 || i > len(time.RFC3339Nano) {
+		return time.Time{}, data
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(data[:i]))
+	if err != nil {
+		return time.Time{}, data
 	}
+	return ts, data[i+1:]
 }
 
 type NamedReader struct {
-	Reader io.Reader
-	Name   string
-	ID     string
-	Stream string
+	Reader    io.Reader
+	Name      string
+	ID        string
+	Image     string
+	Labels    map[string]string
+	Stream    string
+	Multiline *MultilineRule
 }
 
 func (r *NamedReader) Read(p []byte) (n int, err error) {
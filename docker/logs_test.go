@@ -0,0 +1,87 @@
+package docker
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingHandler struct {
+	sync.Mutex
+	records []*LogRecord
+}
+
+func (h *recordingHandler) HandleLog(rec *LogRecord) error {
+	h.Lock()
+	defer h.Unlock()
+	h.records = append(h.records, rec)
+	return nil
+}
+
+// writeLogs tails input with a tailer and returns the records sent.
+func writeLogs(t *testing.T, maxLineSize int, input string) []*LogRecord {
+	state, _ := NewLogState("")
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		State:       state,
+		MaxLineSize: maxLineSize,
+		ctx:         context.Background(),
+	}
+	h := &recordingHandler{}
+	tailer.AddLogHandler(h)
+	tailer.WriteLogs(&NamedReader{
+		Name:   "web",
+		ID:     "abc",
+		Stream: "stdout",
+		Reader: strings.NewReader(input),
+	})
+	if err := tailer.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return h.records
+}
+
+func TestWriteLogsTruncatesLongLines(t *testing.T) {
+	records := writeLogs(t, 10, "2016-01-01T00:00:01Z short\n2016-01-01T00:00:02Z "+strings.Repeat("x", 100000)+"\n2016-01-01T00:00:03Z next\n")
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	if records[0].Truncated || records[0].Message != "short\n" {
+		t.Fatalf("unexpected record %+v", records[0])
+	}
+	if !records[1].Truncated || records[1].Message != strings.Repeat("x", 10)+"\n" {
+		t.Fatalf("expected truncated record, got %q truncated=%v", records[1].Message, records[1].Truncated)
+	}
+	if records[2].Message != "next\n" {
+		t.Fatalf("expected the line after a long line to be kept, got %q", records[2].Message)
+	}
+}
+
+func TestWriteLogsPartialLineAtEOF(t *testing.T) {
+	records := writeLogs(t, 0, "2016-01-01T00:00:01Z one\n2016-01-01T00:00:02Z no newline")
+	if len(records) != 2 || records[1].Message != "no newline" {
+		t.Fatalf("expected the last partial line to be sent, got %d records", len(records))
+	}
+}
+
+func TestWriteLogsJoinsPartialMessages(t *testing.T) {
+	chunk := strings.Repeat("a", partialSize)
+	input := "2016-01-01T00:00:01.000000001Z " + chunk +
+		"2016-01-01T00:00:01.000000002Z " + chunk +
+		"2016-01-01T00:00:01.000000003Z end\n"
+	records := writeLogs(t, 0, input)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if records[0].Message != chunk+chunk+"end\n" || records[0].Truncated {
+		t.Fatalf("expected chunks to be joined, got %d bytes", len(records[0].Message))
+	}
+}
+
+func TestTruncateKeepsCharacters(t *testing.T) {
+	if got := string(truncate([]byte("héllo\n"), 2)); got != "h" {
+		t.Fatalf("expected truncation before a split character, got %q", got)
+	}
+}
@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+	"github.com/jwilder/hud/metrics"
+)
+
+const (
+	DefaultMultilineMaxLines = 500
+	DefaultMultilineMaxBytes = 64 * 1024
+	DefaultMultilineTimeout  = time.Second
+)
+
+// MultilineRule joins the lines of a log event, like a stack trace, into a
+// single LogRecord for the containers it selects.  A line matching Start
+// begins a new event and, if Continue is set, lines matching Continue are
+// added to the current event.  With only Start, every other line is added
+// to the current event; with only Continue, every other line begins a new
+// event.  An event is sent when the next one begins, when it reaches
+// MaxLines or MaxBytes, or when no line was added for Timeout.
+type MultilineRule struct {
+	Selector Selector
+	Start    *regexp.Regexp
+	Continue *regexp.Regexp
+	MaxLines int
+	MaxBytes int
+	Timeout  time.Duration
+}
+
+// continues returns true if line belongs to the current event.
+func (r *MultilineRule) continues(line string) bool {
+	if r.Start != nil && r.Start.MatchString(line) {
+		return false
+	}
+	if r.Continue != nil {
+		return r.Continue.MatchString(line)
+	}
+	return true
+}
+
+// multilineRule returns the first rule selecting a container, or nil.
+func (t *Tailer) multilineRule(container *dockerapi.Container) *MultilineRule {
+	for _, rule := range t.Multiline {
+		if rule.Selector.Match(container) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// multiline assembles the events of a stream of a container.
+type multiline struct {
+	sync.Mutex
+	rule    *MultilineRule
+	send    func(*LogRecord)
+	pending *LogRecord
+	lines   int
+	timer   *time.Timer
+}
+
+func newMultiline(rule *MultilineRule, send func(*LogRecord)) *multiline {
+	r := *rule
+	if r.MaxLines <= 0 {
+		r.MaxLines = DefaultMultilineMaxLines
+	}
+	if r.MaxBytes <= 0 {
+		r.MaxBytes = DefaultMultilineMaxBytes
+	}
+	if r.Timeout <= 0 {
+		r.Timeout = DefaultMultilineTimeout
+	}
+
+	m := &multiline{
+		rule: &r,
+		send: send,
+	}
+	m.timer = time.AfterFunc(time.Hour, m.Flush)
+	m.timer.Stop()
+	return m
+}
+
+// Add adds a line to the current event or begins a new one.
+func (m *multiline) Add(rec *LogRecord) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.pending != nil && m.rule.continues(rec.Message) &&
+		m.lines < m.rule.MaxLines && len(m.pending.Message)+len(rec.Message) <= m.rule.MaxBytes {
+		m.pending.Message += rec.Message
+		m.pending.Truncated = m.pending.Truncated || rec.Truncated
+		if rec.checkpoint.After(m.pending.checkpoint) {
+			m.pending.checkpoint = rec.checkpoint
+		}
+		m.lines++
+	} else {
+		m.flush()
+		m.pending = rec
+		m.lines = 1
+	}
+	m.timer.Reset(m.rule.Timeout)
+}
+
+// Flush sends the current event.
+func (m *multiline) Flush() {
+	m.Lock()
+	defer m.Unlock()
+	m.flush()
+}
+
+func (m *multiline) flush() {
+	m.timer.Stop()
+	if m.pending == nil {
+		return
+	}
+	if m.lines > 1 {
+		metrics.Self.RecordCount("hud.logs.multiline", 1)
+	}
+	m.send(m.pending)
+	m.pending = nil
+	m.lines = 0
+}
@@ -0,0 +1,143 @@
+package docker
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+type sentRecords struct {
+	sync.Mutex
+	messages []string
+}
+
+func (s *sentRecords) send(rec *LogRecord) {
+	s.Lock()
+	defer s.Unlock()
+	s.messages = append(s.messages, rec.Message)
+}
+
+func (s *sentRecords) get() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.messages...)
+}
+
+func addLines(m *multiline, lines ...string) {
+	for _, line := range lines {
+		m.Add(&LogRecord{Message: line})
+	}
+}
+
+func TestMultilineStart(t *testing.T) {
+	sent := &sentRecords{}
+	m := newMultiline(&MultilineRule{
+		Start: regexp.MustCompile(`^\d{4}-`),
+	}, sent.send)
+
+	addLines(m,
+		"2016-01-01 panic\n",
+		"  at main()\n",
+		"  at init()\n",
+		"2016-01-01 next\n",
+	)
+	m.Flush()
+
+	got := sent.get()
+	if len(got) != 2 || got[0] != "2016-01-01 panic\n  at main()\n  at init()\n" || got[1] != "2016-01-01 next\n" {
+		t.Fatalf("unexpected events %q", got)
+	}
+}
+
+func TestMultilineContinue(t *testing.T) {
+	sent := &sentRecords{}
+	m := newMultiline(&MultilineRule{
+		Continue: regexp.MustCompile(`^\s`),
+	}, sent.send)
+
+	addLines(m, "one\n", "two\n", "\tmore\n", "three\n")
+	m.Flush()
+
+	got := sent.get()
+	if len(got) != 3 || got[1] != "two\n\tmore\n" {
+		t.Fatalf("unexpected events %q", got)
+	}
+}
+
+func TestMultilineLimits(t *testing.T) {
+	sent := &sentRecords{}
+	m := newMultiline(&MultilineRule{
+		Continue: regexp.MustCompile(`^\s`),
+		MaxLines: 2,
+	}, sent.send)
+
+	addLines(m, "a\n", " b\n", " c\n")
+	m.Flush()
+	if got := sent.get(); len(got) != 2 || got[0] != "a\n b\n" {
+		t.Fatalf("expected max lines to split the event, got %q", got)
+	}
+
+	sent = &sentRecords{}
+	m = newMultiline(&MultilineRule{
+		Continue: regexp.MustCompile(`^\s`),
+		MaxBytes: 4,
+	}, sent.send)
+
+	addLines(m, "a\n", " b\n")
+	m.Flush()
+	if got := sent.get(); len(got) != 2 {
+		t.Fatalf("expected max bytes to split the event, got %q", got)
+	}
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	sent := &sentRecords{}
+	m := newMultiline(&MultilineRule{
+		Start:   regexp.MustCompile(`^\S`),
+		Timeout: 10 * time.Millisecond,
+	}, sent.send)
+
+	addLines(m, "a\n", " b\n")
+	for i := 0; i < 100 && len(sent.get()) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := sent.get(); len(got) != 1 || got[0] != "a\n b\n" {
+		t.Fatalf("expected the event to be flushed after the timeout, got %q", got)
+	}
+}
+
+func TestSelector(t *testing.T) {
+	container := &dockerapi.Container{
+		Name: "/web-1",
+		Config: &dockerapi.Config{
+			Image:  "nginx:latest",
+			Labels: map[string]string{"tier": "frontend"},
+		},
+	}
+
+	tests := []struct {
+		selector Selector
+		match    bool
+	}{
+		{Selector{}, true},
+		{Selector{Names: []string{"web-*"}}, true},
+		{Selector{Names: []string{"db-*"}}, false},
+		{Selector{Images: []string{"nginx:*"}}, true},
+		{Selector{Labels: map[string]string{"tier": "front*"}}, true},
+		{Selector{Labels: map[string]string{"tier": "backend"}}, false},
+		{Selector{Labels: map[string]string{"team": "*"}}, false},
+	}
+	for _, test := range tests {
+		if got := test.selector.Match(container); got != test.match {
+			t.Errorf("%+v: expected match %v, got %v", test.selector, test.match, got)
+		}
+	}
+
+	bad := Selector{Names: []string{"["}}
+	if err := bad.Validate(); err == nil {
+		t.Errorf("expected bad pattern to be rejected")
+	}
+}
@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jwilder/hud/metrics"
+)
+
+// Keys of structured log lines lifted into the fields of a LogRecord.
+var (
+	levelKeys = []string{"level", "lvl", "severity"}
+	timeKeys  = []string{"time", "ts", "timestamp", "@timestamp"}
+	msgKeys   = []string{"msg", "message"}
+)
+
+// ParseRule parses the structured log lines of the containers it selects
+// into the Fields of their records.  Format is json, logfmt or empty to
+// detect either.  The level, time and message keys are lifted into Level,
+// Ts and Message.  Lines that can not be parsed are left unchanged.
+type ParseRule struct {
+	Selector Selector
+	Format   string
+}
+
+// parse parses a log line with the first rule selecting its container.
+func (t *Tailer) parse(rec *LogRecord) {
+	for _, rule := range t.Parse {
+		if !rule.Selector.MatchNames(rec.ContainerName, rec.Image, rec.Labels) {
+			continue
+		}
+		if format, ok := parseFields(rec, rule.Format); ok {
+			metrics.Self.RecordCount("hud.logs.parsed", 1, t.Broadcaster.tags(metrics.PathTag("format", format))...)
+		}
+		return
+	}
+}
+
+// parseFields parses the message of a record in format and lifts its level,
+// time and message keys.  It returns the format parsed and false if the
+// message is not structured.
+func parseFields(rec *LogRecord, format string) (string, bool) {
+	line := strings.TrimSpace(rec.Message)
+	var fields map[string]interface{}
+	switch {
+	case (format == "" || format == "json") && strings.HasPrefix(line, "{"):
+		fields = parseJSON(line)
+		format = "json"
+	case format == "" || format == "logfmt":
+		fields = parseLogfmt(line)
+		format = "logfmt"
+	}
+	if fields == nil {
+		return "", false
+	}
+
+	rec.Message = ""
+	if msg, ok := lift(fields, msgKeys); ok {
+		rec.Message = msg + "\n"
+	}
+	if level, ok := lift(fields, levelKeys); ok {
+		rec.Level = strings.ToLower(level)
+	}
+	for _, key := range timeKeys {
+		s, ok := fields[key].(string)
+		if !ok {
+			continue
+		}
+		if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			rec.Ts = ts
+			delete(fields, key)
+			break
+		}
+	}
+	rec.Fields = fields
+	return format, true
+}
+
+// lift removes the first of keys with a string value from fields and
+// returns its value.
+func lift(fields map[string]interface{}, keys []string) (string, bool) {
+	for _, key := range keys {
+		if s, ok := fields[key].(string); ok {
+			delete(fields, key)
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// parseJSON returns the fields of a JSON object, or nil.  Numbers are kept
+// as json.Number so they are written back unchanged.
+func parseJSON(line string) map[string]interface{} {
+	var fields map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader([]byte(line)))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil || d.More() {
+		return nil
+	}
+	return fields
+}
+
+// parseLogfmt returns the fields of a line of space separated key=value
+// pairs, or nil.  Values can be quoted.
+func parseLogfmt(line string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			break
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' && line[i] != '"' {
+			i++
+		}
+		if i == start || i == len(line) || line[i] != '=' {
+			return nil
+		}
+		key := line[start:i]
+		i++
+
+		var value string
+		if i < len(line) && line[i] == '"' {
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				return nil
+			}
+			v, err := strconv.Unquote(line[i : j+1])
+			if err != nil {
+				return nil
+			}
+			value, i = v, j+1
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil
+			}
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				i++
+			}
+			value = line[start:i]
+			if strings.ContainsAny(value, "=\"") {
+				return nil
+			}
+		}
+		fields[key] = value
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseJSON(t *testing.T) {
+	rec := &LogRecord{Message: `{"level":"WARN","time":"2016-01-02T03:04:05.5Z","msg":"slow query","ms":1200,"db":{"name":"orders"}}` + "\n"}
+	if format, ok := parseFields(rec, ""); !ok || format != "json" {
+		t.Fatalf("expected JSON to be parsed, got %q", format)
+	}
+
+	if rec.Message != "slow query\n" || rec.Level != "warn" {
+		t.Fatalf("expected msg and level to be lifted, got %q %q", rec.Message, rec.Level)
+	}
+	if !rec.Ts.Equal(time.Date(2016, 1, 2, 3, 4, 5, 500000000, time.UTC)) {
+		t.Fatalf("expected time to be lifted, got %s", rec.Ts)
+	}
+	if rec.Fields["ms"] != json.Number("1200") || len(rec.Fields) != 2 {
+		t.Fatalf("unexpected fields %v", rec.Fields)
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	rec := &LogRecord{Message: `level=info msg="request done" path=/orders status=200 user="jane \"jd\" doe"` + "\n"}
+	if format, ok := parseFields(rec, ""); !ok || format != "logfmt" {
+		t.Fatalf("expected logfmt to be parsed, got %q", format)
+	}
+	if rec.Message != "request done\n" || rec.Level != "info" {
+		t.Fatalf("expected msg and level to be lifted, got %q %q", rec.Message, rec.Level)
+	}
+	if rec.Fields["path"] != "/orders" || rec.Fields["status"] != "200" || rec.Fields["user"] != `jane "jd" doe` {
+		t.Fatalf("unexpected fields %v", rec.Fields)
+	}
+}
+
+func TestParseUnstructured(t *testing.T) {
+	for _, line := range []string{
+		"GET /orders 200\n",
+		"retrying in 5s attempt=3\n",
+		`{"truncated": ` + "\n",
+		`msg="unterminated` + "\n",
+		"a=b=c\n",
+	} {
+		rec := &LogRecord{Message: line}
+		if _, ok := parseFields(rec, ""); ok || rec.Message != line || rec.Fields != nil {
+			t.Errorf("expected %q to be left unchanged, got %+v", line, rec)
+		}
+	}
+
+	rec := &LogRecord{Message: "level=info\n"}
+	if _, ok := parseFields(rec, "json"); ok {
+		t.Errorf("expected logfmt not to be parsed as json")
+	}
+}
+
+func TestRedactFields(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		Parse:       []*ParseRule{{}},
+		Redactions:  []*Redaction{{Rules: BuiltinRedactRules("email")}},
+	}
+	rec := &LogRecord{Message: `{"msg":"signup","user":{"email":"bob@example.com"}}`}
+	tailer.parse(rec)
+	tailer.redact(rec)
+	user := rec.Fields["user"].(map[string]interface{})
+	if user["email"] != Redacted {
+		t.Fatalf("expected nested fields to be redacted, got %v", rec.Fields)
+	}
+}
+
+func TestRedactNumericFields(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		Parse:       []*ParseRule{{}},
+		Redactions:  []*Redaction{{Rules: BuiltinRedactRules("card")}},
+	}
+	rec := &LogRecord{Message: `{"msg":"charged","card":4111111111111111,"amount":12}`}
+	tailer.parse(rec)
+	tailer.redact(rec)
+	if rec.Fields["card"] != Redacted || rec.Fields["amount"] != json.Number("12") {
+		t.Fatalf("expected numeric card number to be redacted, got %v", rec.Fields)
+	}
+}
+
+func TestRedactKeyAnchoredFields(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		Parse:       []*ParseRule{{}},
+		Redactions:  []*Redaction{{Rules: BuiltinRedactRules("aws")}},
+	}
+	secret := "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY"
+	for _, line := range []string{
+		`{"event":"login","aws_secret_access_key":"` + secret + `"}`,
+		`event=login aws_secret_access_key=` + secret,
+	} {
+		rec := &LogRecord{Message: line}
+		tailer.parse(rec)
+		tailer.redact(rec)
+		if rec.Fields["aws_secret_access_key"] != Redacted || rec.Fields["event"] != "login" {
+			t.Fatalf("expected the secret of %s to be redacted, got %v", line, rec.Fields)
+		}
+	}
+}
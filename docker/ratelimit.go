@@ -0,0 +1,172 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jwilder/hud/metrics"
+)
+
+// SuppressedSummaryInterval is how often a record summarizing the lines
+// suppressed by rate limits is sent for each container.
+const SuppressedSummaryInterval = 10 * time.Second
+
+// RateLimit limits the log lines sent by each container it selects.  If
+// Sample is above 1, one line in Sample is kept.  If Rate is set, the lines
+// kept are then limited to Rate lines per second with bursts of Burst
+// lines, which defaults to Rate.  Suppressed lines are counted and
+// summarized in a log record every SuppressedSummaryInterval.
+type RateLimit struct {
+	Selector Selector
+	Rate     float64
+	Burst    int
+	Sample   int
+}
+
+// limiter is the token bucket and sampling counter of a container.
+type limiter struct {
+	limit      *RateLimit
+	burst      float64
+	tokens     float64
+	last       time.Time
+	lines      int64
+	suppressed map[string]int64
+	rec        LogRecord
+}
+
+func newLimiter(limit *RateLimit, rec *LogRecord) *limiter {
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &limiter{
+		limit:      limit,
+		burst:      burst,
+		tokens:     burst,
+		last:       rec.Received,
+		suppressed: map[string]int64{},
+		rec: LogRecord{
+			Daemon:        rec.Daemon,
+			ContainerID:   rec.ContainerID,
+			ContainerName: rec.ContainerName,
+			Image:         rec.Image,
+			Labels:        rec.Labels,
+		},
+	}
+}
+
+// allow returns true if a line read at now is sent.
+func (l *limiter) allow(now time.Time) bool {
+	l.lines++
+	if l.limit.Sample > 1 && (l.lines-1)%int64(l.limit.Sample) != 0 {
+		return false
+	}
+	if l.limit.Rate <= 0 {
+		return true
+	}
+	if now.After(l.last) {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate)
+		l.last = now
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// summaries returns a record for each stream with suppressed lines and
+// resets the counts.
+func (l *limiter) summaries(now time.Time) []*LogRecord {
+	streams := []string{}
+	for stream := range l.suppressed {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+
+	records := []*LogRecord{}
+	for _, stream := range streams {
+		rec := l.rec
+		rec.Ts, rec.Received = now, now
+		rec.Stream = stream
+		rec.Message = fmt.Sprintf("hud: %d lines suppressed by rate limit\n", l.suppressed[stream])
+		records = append(records, &rec)
+		delete(l.suppressed, stream)
+	}
+	return records
+}
+
+// rateLimit returns the first rate limit selecting a container, or nil.
+func (t *Tailer) rateLimit(rec *LogRecord) *RateLimit {
+	for _, limit := range t.RateLimits {
+		if limit.Selector.MatchNames(rec.ContainerName, rec.Image, rec.Labels) {
+			return limit
+		}
+	}
+	return nil
+}
+
+// allow returns false if the rate limit of its container suppresses a line.
+func (t *Tailer) allow(rec *LogRecord) bool {
+	if len(t.RateLimits) == 0 {
+		return true
+	}
+
+	t.limitLock.Lock()
+	defer t.limitLock.Unlock()
+	l, ok := t.limiters[rec.ContainerID]
+	if !ok {
+		limit := t.rateLimit(rec)
+		if t.limiters == nil {
+			t.limiters = map[string]*limiter{}
+		}
+		if limit != nil {
+			l = newLimiter(limit, rec)
+		}
+		// containers without a limit are remembered as nil
+		t.limiters[rec.ContainerID] = l
+	}
+	if l == nil || l.allow(rec.Received) {
+		return true
+	}
+	l.suppressed[rec.Stream]++
+	metrics.Self.RecordCount("hud.logs.suppressed", 1, t.Broadcaster.tags(metrics.PathTag("container", rec.ContainerName))...)
+	return false
+}
+
+// summarize sends the summaries of the lines suppressed for a container, or
+// for every container if id is empty.  If forget is set, the containers are
+// forgotten.
+func (t *Tailer) summarize(id string, forget bool) {
+	now := time.Now()
+	records := []*LogRecord{}
+	t.limitLock.Lock()
+	for cid, l := range t.limiters {
+		if id != "" && cid != id {
+			continue
+		}
+		if l != nil {
+			records = append(records, l.summaries(now)...)
+		}
+		if forget {
+			delete(t.limiters, cid)
+		}
+	}
+	t.limitLock.Unlock()
+
+	for _, rec := range records {
+		t.fanOut(rec)
+	}
+}
+
+// summarizeForever sends the summaries of suppressed lines every
+// SuppressedSummaryInterval until ctx is cancelled.
+func (t *Tailer) summarizeForever(ctx context.Context) {
+	for sleep(ctx, SuppressedSummaryInterval) {
+		t.summarize("", false)
+	}
+	t.summarize("", true)
+}
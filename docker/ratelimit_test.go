@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newLimiter(&RateLimit{Rate: 2, Burst: 3}, &LogRecord{Received: start})
+
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if l.allow(start) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("expected a burst of 3 lines, got %d", allowed)
+	}
+
+	if !l.allow(start.Add(500*time.Millisecond)) || l.allow(start.Add(500*time.Millisecond)) {
+		t.Fatalf("expected one line to be allowed after half a second at 2 lines/s")
+	}
+
+	l = newLimiter(&RateLimit{Sample: 3}, &LogRecord{Received: start})
+	allowed = 0
+	for i := 0; i < 9; i++ {
+		if l.allow(start) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("expected one line in 3 to be sampled, got %d of 9", allowed)
+	}
+}
+
+func TestRateLimitSummary(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		RateLimits: []*RateLimit{
+			{Selector: Selector{Names: []string{"noisy"}}, Rate: 1},
+		},
+	}
+	h := &recordingHandler{}
+	tailer.AddLogHandler(h)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		tailer.notifyLog(&LogRecord{ContainerID: "a", ContainerName: "noisy", Stream: "stdout", Received: now, Message: "spam\n"})
+		tailer.notifyLog(&LogRecord{ContainerID: "b", ContainerName: "quiet", Stream: "stdout", Received: now, Message: "ok\n"})
+	}
+	tailer.summarize("", true)
+	if err := tailer.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	var summary *LogRecord
+	for _, rec := range h.records {
+		counts[rec.ContainerName]++
+		if rec.Message == "hud: 4 lines suppressed by rate limit\n" {
+			summary = rec
+		}
+	}
+	if counts["noisy"] != 2 || counts["quiet"] != 5 {
+		t.Fatalf("expected 1 noisy line, its summary and 5 quiet lines, got %v", counts)
+	}
+	if summary == nil || summary.ContainerID != "a" || summary.Stream != "stdout" {
+		t.Fatalf("expected a summary of the suppressed lines, got %+v", h.records)
+	}
+}
+
+func TestFanOutDoesNotBlock(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		logHandlers: map[LogHandler]LogChannel{
+			&recordingHandler{}: make(LogChannel),
+		},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tailer.notifyLog(&LogRecord{ContainerName: "web", Message: "hello\n"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a full handler queue to drop the record")
+	}
+}
@@ -0,0 +1,180 @@
+package docker
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/jwilder/hud/metrics"
+)
+
+// Redacted replaces the sensitive data removed from log lines.
+const Redacted = "[REDACTED]"
+
+// BuiltinRedactions are the names of the built-in redaction patterns.
+var BuiltinRedactions = []string{"bearer", "aws", "email", "card"}
+
+// Redaction replaces sensitive data in the log lines of the containers it
+// selects.  Its rules are applied in order.
+type Redaction struct {
+	Selector Selector
+	Rules    []*RedactRule
+}
+
+// RedactRule replaces the matches of Pattern with Replacement, which can
+// refer to submatches as $1.  Name tags the metrics of the rule.
+type RedactRule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Replacement string
+
+	// valid, if set, checks a match is really sensitive before it is
+	// replaced.  Submatches can not be referred to then.
+	valid func(match string) bool
+}
+
+// BuiltinRedactRules returns the rules of a built-in redaction pattern, or
+// nil if there is none by that name.
+func BuiltinRedactRules(name string) []*RedactRule {
+	switch name {
+	case "bearer":
+		return []*RedactRule{{
+			Name:        name,
+			Pattern:     regexp.MustCompile(`(?i)\b(bearer\s+)[A-Za-z0-9\-._~+/]{8,}=*`),
+			Replacement: "${1}" + Redacted,
+		}}
+	case "aws":
+		return []*RedactRule{{
+			Name:        name,
+			Pattern:     regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+			Replacement: Redacted,
+		}, {
+			Name:        name,
+			Pattern:     regexp.MustCompile(`(?i)(aws_secret_access_key["']?\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`),
+			Replacement: "${1}" + Redacted,
+		}}
+	case "email":
+		return []*RedactRule{{
+			Name:        name,
+			Pattern:     regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+			Replacement: Redacted,
+		}}
+	case "card":
+		return []*RedactRule{{
+			Name:        name,
+			Pattern:     regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+			Replacement: Redacted,
+			valid:       isCardNumber,
+		}}
+	}
+	return nil
+}
+
+// apply returns msg with the matches of the rule replaced.
+func (r *RedactRule) apply(msg string) string {
+	if r.valid == nil {
+		return r.Pattern.ReplaceAllString(msg, r.Replacement)
+	}
+	return r.Pattern.ReplaceAllStringFunc(msg, func(match string) string {
+		if !r.valid(match) {
+			return match
+		}
+		return r.Replacement
+	})
+}
+
+// applyValue replaces the matches of the rule in the strings of a parsed
+// field value.  Rules anchored on a key name, like aws_secret_access_key=,
+// are matched against key=value as the key is no longer part of the value.
+// It returns false if nothing was replaced.
+func (r *RedactRule) applyValue(key string, value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		s := r.applyField(key, v)
+		return s, s != v
+	case json.Number:
+		if s := r.applyField(key, v.String()); s != v.String() {
+			return s, true
+		}
+		return v, false
+	case map[string]interface{}:
+		redacted := false
+		for k, item := range v {
+			if item, ok := r.applyValue(k, item); ok {
+				v[k] = item
+				redacted = true
+			}
+		}
+		return v, redacted
+	case []interface{}:
+		redacted := false
+		for i, item := range v {
+			if item, ok := r.applyValue(key, item); ok {
+				v[i] = item
+				redacted = true
+			}
+		}
+		return v, redacted
+	}
+	return value, false
+}
+
+// applyField returns the value of a field with the matches of the rule in
+// the value or in key=value replaced.
+func (r *RedactRule) applyField(key, value string) string {
+	if s := r.apply(value); s != value {
+		return s
+	}
+	prefix := key + "="
+	if s := r.apply(prefix + value); strings.HasPrefix(s, prefix) {
+		return strings.TrimPrefix(s, prefix)
+	}
+	return value
+}
+
+// redact replaces the sensitive data of a log line and its fields.
+func (t *Tailer) redact(rec *LogRecord) {
+	for _, r := range t.Redactions {
+		if !r.Selector.MatchNames(rec.ContainerName, rec.Image, rec.Labels) {
+			continue
+		}
+		for _, rule := range r.Rules {
+			msg := rule.apply(rec.Message)
+			redacted := msg != rec.Message
+			rec.Message = msg
+			for key, value := range rec.Fields {
+				if v, ok := rule.applyValue(key, value); ok {
+					rec.Fields[key] = v
+					redacted = true
+				}
+			}
+			if redacted {
+				metrics.Self.RecordCount("hud.logs.redacted", 1, t.Broadcaster.tags(metrics.PathTag("rule", rule.Name))...)
+			}
+		}
+	}
+}
+
+// isCardNumber returns true if the digits of a number pass the Luhn check
+// of card numbers.
+func isCardNumber(number string) bool {
+	sum, digits := 0, 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"bufio"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
+func TestRedactGolden(t *testing.T) {
+	rules := []*RedactRule{}
+	for _, name := range BuiltinRedactions {
+		rules = append(rules, BuiltinRedactRules(name)...)
+	}
+	rules = append(rules, &RedactRule{
+		Name:        "password",
+		Pattern:     regexp.MustCompile(`(password=)\S+`),
+		Replacement: "${1}***",
+	})
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		Redactions:  []*Redaction{{Rules: rules}},
+	}
+
+	input, err := os.Open(filepath.Join("testdata", "redact.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	var out []string
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		rec := &LogRecord{ContainerName: "web", Message: scanner.Text()}
+		tailer.redact(rec)
+		out = append(out, rec.Message)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "redact.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(strings.Join(out, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(expected) != len(out) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(out))
+	}
+	for i := range out {
+		if out[i] != expected[i] {
+			t.Errorf("line %d:\nexpected %q\ngot      %q", i+1, expected[i], out[i])
+		}
+	}
+}
+
+func TestRedactSelector(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		Redactions: []*Redaction{{
+			Selector: Selector{Labels: map[string]string{"pii": "true"}},
+			Rules:    BuiltinRedactRules("email"),
+		}},
+	}
+
+	rec := &LogRecord{ContainerName: "web", Message: "mail bob@example.com"}
+	tailer.redact(rec)
+	if rec.Message != "mail bob@example.com" {
+		t.Fatalf("expected unselected container to be left alone, got %q", rec.Message)
+	}
+
+	rec = &LogRecord{ContainerName: "web", Labels: map[string]string{"pii": "true"}, Message: "mail bob@example.com"}
+	tailer.redact(rec)
+	if rec.Message != "mail "+Redacted {
+		t.Fatalf("expected email to be redacted, got %q", rec.Message)
+	}
+}
@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/jwilder/hud/metrics"
+)
+
+// LogRule drops or keeps the log lines of the containers and streams it
+// selects whose message matches Match or contains Contains.  Rules are
+// evaluated in order and the first matching rule decides, so a keep rule
+// can make an exception to the drop rules after it.  Lines matching no rule
+// are kept.
+type LogRule struct {
+	Name     string
+	Selector Selector
+	Streams  []string
+	Match    *regexp.Regexp
+	Contains string
+	Keep     bool
+}
+
+// matches returns true if the rule applies to a log line.
+func (r *LogRule) matches(rec *LogRecord) bool {
+	if !r.Selector.MatchNames(rec.ContainerName, rec.Image, rec.Labels) {
+		return false
+	}
+	if len(r.Streams) > 0 && !contains(r.Streams, rec.Stream) {
+		return false
+	}
+	if r.Match != nil && !r.Match.MatchString(rec.Message) {
+		return false
+	}
+	return r.Contains == "" || strings.Contains(rec.Message, r.Contains)
+}
+
+// keep returns false if a rule drops a log line.
+func (t *Tailer) keep(rec *LogRecord) bool {
+	for _, rule := range t.Rules {
+		if !rule.matches(rec) {
+			continue
+		}
+		if !rule.Keep {
+			metrics.Self.RecordCount("hud.logs.filtered", 1, t.Broadcaster.tags(metrics.PathTag("rule", rule.Name))...)
+		}
+		return rule.Keep
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
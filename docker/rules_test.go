@@ -0,0 +1,48 @@
+package docker
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestLogRules(t *testing.T) {
+	tailer := &Tailer{
+		Broadcaster: &Broadcaster{},
+		Rules: []*LogRule{
+			{
+				Name:     "health-errors",
+				Contains: "/health",
+				Match:    regexp.MustCompile(`" 5\d\d `),
+				Keep:     true,
+			},
+			{
+				Name:     "health",
+				Selector: Selector{Names: []string{"web-*"}},
+				Streams:  []string{"stdout"},
+				Contains: "GET /health",
+			},
+			{
+				Name:  "debug",
+				Match: regexp.MustCompile(`^DEBUG`),
+			},
+		},
+	}
+
+	tests := []struct {
+		rec  LogRecord
+		keep bool
+	}{
+		{LogRecord{ContainerName: "web-1", Stream: "stdout", Message: `"GET /health HTTP/1.1" 200 2`}, false},
+		{LogRecord{ContainerName: "web-1", Stream: "stdout", Message: `"GET /health HTTP/1.1" 503 2`}, true},
+		{LogRecord{ContainerName: "web-1", Stream: "stderr", Message: `"GET /health HTTP/1.1" 200 2`}, true},
+		{LogRecord{ContainerName: "api-1", Stream: "stdout", Message: `"GET /health HTTP/1.1" 200 2`}, true},
+		{LogRecord{ContainerName: "api-1", Stream: "stdout", Message: "DEBUG cache miss"}, false},
+		{LogRecord{ContainerName: "api-1", Stream: "stdout", Message: "INFO started"}, true},
+	}
+	for _, test := range tests {
+		rec := test.rec
+		if got := tailer.keep(&rec); got != test.keep {
+			t.Errorf("%s %q: expected keep %v, got %v", rec.ContainerName, rec.Message, test.keep, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+// Selector selects containers by name, image and labels.  Names, images and
+// label values are glob patterns.  A container matches if it matches one of
+// the names, one of the images and all of the labels.  Empty fields match
+// every container.
+type Selector struct {
+	Names  []string
+	Images []string
+	Labels map[string]string
+}
+
+// Match returns true if the selector matches a container.
+func (s *Selector) Match(container *dockerapi.Container) bool {
+	name := strings.TrimPrefix(container.Name, "/")
+	var image string
+	var labels map[string]string
+	if container.Config != nil {
+		image = container.Config.Image
+		labels = container.Config.Labels
+	}
+	return s.MatchNames(name, image, labels)
+}
+
+// MatchNames returns true if the selector matches a container with the
+// given name, image and labels.
+func (s *Selector) MatchNames(name, image string, labels map[string]string) bool {
+	if !matchAny(s.Names, name) || !matchAny(s.Images, image) {
+		return false
+	}
+	for key, pattern := range s.Labels {
+		value, ok := labels[key]
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate returns an error if a pattern of the selector is malformed.
+func (s *Selector) Validate() error {
+	patterns := append(append([]string{}, s.Names...), s.Images...)
+	for _, pattern := range s.Labels {
+		patterns = append(patterns, pattern)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// LogState keeps the timestamp of the last log line seen on each stream of
+// each container so tailing resumes where it left off after a reconnect or,
+// if it is saved to a file, a restart.
+type LogState struct {
+	sync.Mutex
+	path  string
+	seen  map[string]map[string]time.Time
+	dirty bool
+}
+
+// NewLogState returns the log state saved at path.  If path is empty, the
+// state is only kept in memory.
+func NewLogState(path string) (*LogState, error) {
+	s := &LogState{
+		path: path,
+		seen: map[string]map[string]time.Time{},
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.seen); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Since returns the time to resume tailing a container from: the oldest
+// last-seen time of its streams.  It returns false if the container was
+// never tailed.
+func (s *LogState) Since(id string) (time.Time, bool) {
+	s.Lock()
+	defer s.Unlock()
+	var since time.Time
+	for _, ts := range s.seen[id] {
+		if since.IsZero() || ts.Before(since) {
+			since = ts
+		}
+	}
+	return since, !since.IsZero()
+}
+
+// Handled returns true if the log line with timestamp ts of a stream of a
+// container was already handled.
+func (s *LogState) Handled(id, stream string, ts time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	return !ts.After(s.seen[id][stream])
+}
+
+// Seen records that a log line with timestamp ts of a stream of a container
+// was handled.  It returns false if the line was already seen.
+func (s *LogState) Seen(id, stream string, ts time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	streams, ok := s.seen[id]
+	if !ok {
+		streams = map[string]time.Time{}
+		s.seen[id] = streams
+	}
+	if !ts.After(streams[stream]) {
+		return false
+	}
+	streams[stream] = ts
+	s.dirty = true
+	return true
+}
+
+// Remove forgets a container that was removed.
+func (s *LogState) Remove(id string) {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.seen[id]; ok {
+		delete(s.seen, id)
+		s.dirty = true
+	}
+}
+
+// Prune forgets the containers that are not in ids, which were removed
+// while hud was not running.
+func (s *LogState) Prune(ids map[string]bool) {
+	s.Lock()
+	defer s.Unlock()
+	for id := range s.seen {
+		if !ids[id] {
+			delete(s.seen, id)
+			s.dirty = true
+		}
+	}
+}
+
+// Save writes the state to its file if it changed.  The file is replaced
+// atomically so a crash leaves the previous state.
+func (s *LogState) Save() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(s.seen)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// SaveForever saves the state every interval until ctx is cancelled.
+func (s *LogState) SaveForever(ctx context.Context, interval time.Duration) {
+	for sleep(ctx, interval) {
+		if err := s.Save(); err != nil {
+			log.Errorf("ERROR: Unable to save log state: %s", err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package docker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dockerapi "github.com/fsouza/go-dockerclient"
+)
+
+func TestLogStateResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hud-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := NewLogState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Since("abc"); ok {
+		t.Fatalf("expected no checkpoint for a new container")
+	}
+
+	t1 := time.Date(2016, 1, 1, 0, 0, 1, 500, time.UTC)
+	t2 := t1.Add(time.Second)
+	if !s.Seen("abc", "stdout", t1) || !s.Seen("abc", "stderr", t2) {
+		t.Fatalf("expected new lines to be seen")
+	}
+	if s.Seen("abc", "stdout", t1) {
+		t.Fatalf("expected duplicate line to be dropped")
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewLogState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	since, ok := s.Since("abc")
+	if !ok || !since.Equal(t1) {
+		t.Fatalf("expected to resume from the oldest stream at %s, got %s", t1, since)
+	}
+	if s.Seen("abc", "stderr", t2) || !s.Seen("abc", "stdout", t2) {
+		t.Fatalf("expected checkpoints to be restored per stream")
+	}
+
+	s.Remove("abc")
+	if _, ok := s.Since("abc"); ok {
+		t.Fatalf("expected removed container to be forgotten")
+	}
+}
+
+func TestSplitTimestamp(t *testing.T) {
+	ts, line := splitTimestamp([]byte("2016-01-02T03:04:05.123456789Z hello world\n"))
+	if ts.Nanosecond() != 123456789 || string(line) != "hello world\n" {
+		t.Fatalf("unexpected split %s %q", ts, line)
+	}
+
+	ts, line = splitTimestamp([]byte("hello world\n"))
+	if !ts.IsZero() || string(line) != "hello world\n" {
+		t.Fatalf("expected line without timestamp to be unchanged, got %s %q", ts, line)
+	}
+}
+
+func TestSetLogsStart(t *testing.T) {
+	state, _ := NewLogState("")
+	tailer := &Tailer{State: state}
+	started := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		container *dockerapi.Container
+		fromStart bool
+		since     int64
+		tail      string
+	}{
+		{&dockerapi.Container{ID: "a", State: dockerapi.State{StartedAt: started}}, true, started.Unix(), ""},
+		{&dockerapi.Container{ID: "a", State: dockerapi.State{StartedAt: started}}, false, 0, "0"},
+		{&dockerapi.Container{ID: "a"}, true, 0, ""},
+	}
+	for i, test := range tests {
+		opts := dockerapi.LogsOptions{}
+		tailer.setLogsStart(&opts, test.container, test.fromStart)
+		if opts.Since != test.since || opts.Tail != test.tail {
+			t.Errorf("%d: expected since %d tail %q, got %d %q", i, test.since, test.tail, opts.Since, opts.Tail)
+		}
+	}
+
+	seen := started.Add(time.Hour)
+	state.Seen("a", "stdout", seen)
+	opts := dockerapi.LogsOptions{}
+	tailer.setLogsStart(&opts, &dockerapi.Container{ID: "a"}, true)
+	if opts.Since != seen.Unix() {
+		t.Errorf("expected to resume from the checkpoint, got %d", opts.Since)
+	}
+}
+
+func TestLogStatePrune(t *testing.T) {
+	s, _ := NewLogState("")
+	ts := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Seen("running", "stdout", ts)
+	s.Seen("removed", "stdout", ts)
+
+	s.Prune(map[string]bool{"running": true})
+	if _, ok := s.Since("removed"); ok {
+		t.Fatalf("expected removed container to be pruned")
+	}
+	if _, ok := s.Since("running"); !ok {
+		t.Fatalf("expected running container to be kept")
+	}
+}
+
+func TestCheckpointAfterFanOut(t *testing.T) {
+	state, _ := NewLogState("")
+	tailer := &Tailer{Broadcaster: &Broadcaster{}, State: state}
+	ts := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	rec := &LogRecord{ContainerID: "a", Stream: "stdout", Ts: ts, Message: "hello\n", checkpoint: ts}
+	if state.Handled("a", "stdout", ts) {
+		t.Fatalf("expected a line that was read but not sent not to be handled")
+	}
+	tailer.notifyLog(rec)
+	if !state.Handled("a", "stdout", ts) {
+		t.Fatalf("expected the checkpoint to advance once the line was sent")
+	}
+}
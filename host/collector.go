@@ -1,6 +1,7 @@
 package host
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"strings"
@@ -29,10 +30,27 @@ func NewHostCollector(prefix string, interval int) *HostCollector {
 	return collector
 }
 
-func (h *HostCollector) CollectForever() {
+// sampleInterval is how long cpu, network and disk counters are sampled
+// over to compute rates.
+const sampleInterval = 1 * time.Second
+
+// collectDuration returns the time spent collecting since start, leaving
+// out the sampling interval the collectors wait concurrently.
+func collectDuration(start time.Time) time.Duration {
+	d := time.Since(start) - sampleInterval
+	if d < 0 {
+		// a collector failed before sampling
+		return 0
+	}
+	return d
+}
+
+// CollectForever collects host stats until ctx is cancelled.
+func (h *HostCollector) CollectForever(ctx context.Context) {
 
 	var wg sync.WaitGroup
-	for {
+	for ctx.Err() == nil {
+		start := time.Now()
 		wg.Add(5)
 		go func() {
 			defer wg.Done()
@@ -75,6 +93,7 @@ func (h *HostCollector) CollectForever() {
 		}()
 
 		wg.Wait()
+		metrics.Self.RecordHistogram("hud.collect.duration", nil, collectDuration(start).Seconds(), metrics.PathTag("collector", "host"))
 	}
 }
 
@@ -95,7 +114,7 @@ func (h *HostCollector) collectCpuUtil() error {
 		return err
 	}
 
-	time.Sleep(1 * time.Second)
+	time.Sleep(sampleInterval)
 
 	stopTimes, err := cpu.CPUTimes(true)
 	if err != nil {
@@ -138,25 +157,17 @@ func (h *HostCollector) collectCpuUtil() error {
 		if start.CPU == "cpu-total" {
 			cpu = "all"
 		}
-
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.total.%s", cpu),
-			busy/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.user.%s", cpu),
-			userAll/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.system.%s", cpu),
-			systemAll/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.iowait.%s", cpu),
-			iowait/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.idle.%s", cpu),
-			idleAll/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.nice.%s", cpu),
-			niceAll/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.irq.%s", cpu),
-			irq/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.softirq.%s", cpu),
-			softIrq/period*100)
-		h.RecordGaugeFloat64(fmt.Sprintf("system.cpu.util.steal.%s", cpu),
-			stolen/period*100)
+		tag := metrics.PathTag("cpu", cpu)
+
+		h.RecordGaugeFloat64("system.cpu.util.total", busy/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.user", userAll/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.system", systemAll/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.iowait", iowait/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.idle", idleAll/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.nice", niceAll/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.irq", irq/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.softirq", softIrq/period*100, tag)
+		h.RecordGaugeFloat64("system.cpu.util.steal", stolen/period*100, tag)
 	}
 	return nil
 }
@@ -184,7 +195,7 @@ func (h *HostCollector) collectNet() error {
 		return err
 	}
 
-	time.Sleep(1 * time.Second)
+	time.Sleep(sampleInterval)
 
 	netStop, err := net.NetIOCounters(true)
 	if err != nil {
@@ -208,65 +219,67 @@ func (h *HostCollector) collectNet() error {
 		stop := netStop[i]
 
 		iface := ifByName[start.Name]
+		ifTag := metrics.PathTag("interface", start.Name)
 
 		intv64 := int64(h.interval)
 		bytesSent := int64(stop.BytesSent-start.BytesSent) / intv64
 		bytesRecv := int64(stop.BytesRecv-start.BytesRecv) / intv64
 		bytesTotal := (bytesRecv + bytesSent)
 
-		h.RecordCount(fmt.Sprintf("system.net.bytes.sent.if.%s", start.Name), bytesSent)
-		h.RecordCount(fmt.Sprintf("system.net.bytes.recv.if.%s", start.Name), bytesRecv)
-		h.RecordCount(fmt.Sprintf("system.net.bytes.total.if.%s", start.Name), bytesTotal)
+		h.RecordCount("system.net.bytes.sent.if", bytesSent, ifTag)
+		h.RecordCount("system.net.bytes.recv.if", bytesRecv, ifTag)
+		h.RecordCount("system.net.bytes.total.if", bytesTotal, ifTag)
 
 		packetsSent := int64(stop.PacketsSent-start.PacketsSent) / intv64
 		packetsRecv := int64(stop.PacketsRecv-start.PacketsRecv) / intv64
 		packetsTotal := packetsSent + packetsRecv
 
-		h.RecordCount(fmt.Sprintf("system.net.packets.sent.if.%s", start.Name), packetsSent)
-		h.RecordCount(fmt.Sprintf("system.net.packets.recv.if.%s", start.Name), packetsRecv)
-		h.RecordCount(fmt.Sprintf("system.net.packets.total.if.%s", start.Name), packetsTotal)
+		h.RecordCount("system.net.packets.sent.if", packetsSent, ifTag)
+		h.RecordCount("system.net.packets.recv.if", packetsRecv, ifTag)
+		h.RecordCount("system.net.packets.total.if", packetsTotal, ifTag)
 
 		errIn := int64(stop.Errin-start.Errin) / intv64
 		errOut := int64(stop.Errout-start.Errout) / intv64
 		errTotal := errIn + errOut
 
-		h.RecordCount(fmt.Sprintf("system.net.errors.in.if.%s", start.Name), errIn)
-		h.RecordCount(fmt.Sprintf("system.net.errors.out.if.%s", start.Name), errOut)
-		h.RecordCount(fmt.Sprintf("system.net.errors.total.if.%s", start.Name), errTotal)
+		h.RecordCount("system.net.errors.in.if", errIn, ifTag)
+		h.RecordCount("system.net.errors.out.if", errOut, ifTag)
+		h.RecordCount("system.net.errors.total.if", errTotal, ifTag)
 
 		droppedIn := int64(stop.Dropin-start.Dropin) / intv64
 		droppedOut := int64(stop.Dropout-start.Dropin) / intv64
 		droppedTotal := droppedIn + droppedOut
 
-		h.RecordCount(fmt.Sprintf("system.net.dropped.in.if.%s", start.Name), droppedIn)
-		h.RecordCount(fmt.Sprintf("system.net.dropped.out.if.%s", start.Name), droppedOut)
-		h.RecordCount(fmt.Sprintf("system.net.dropped.total.if.%s", start.Name), droppedTotal)
+		h.RecordCount("system.net.dropped.in.if", droppedIn, ifTag)
+		h.RecordCount("system.net.dropped.out.if", droppedOut, ifTag)
+		h.RecordCount("system.net.dropped.total.if", droppedTotal, ifTag)
 
 		for _, addr := range iface.Addrs {
 			version := "ip4"
 			if strings.Contains(addr.Addr, ":") {
 				version = "ip6"
 			}
-			ip := strings.Replace(addr.Addr, ".", "_", -1)
+			ip := addr.Addr
 			subnet := strings.LastIndex(ip, "/")
 			if subnet != -1 {
 				ip = ip[0:subnet]
 			}
-			h.RecordCount(fmt.Sprintf("system.net.bytes.sent.%s.%s", version, ip), bytesSent)
-			h.RecordCount(fmt.Sprintf("system.net.bytes.recv.%s.%s", version, ip), bytesRecv)
-			h.RecordCount(fmt.Sprintf("system.net.bytes.total.%s.%s", version, ip), bytesTotal)
-
-			h.RecordCount(fmt.Sprintf("system.net.packets.sent.%s.%s", version, ip), packetsSent)
-			h.RecordCount(fmt.Sprintf("system.net.packets.recv.%s.%s", version, ip), packetsRecv)
-			h.RecordCount(fmt.Sprintf("system.net.packets.total.%s.%s", version, ip), packetsTotal)
-
-			h.RecordCount(fmt.Sprintf("system.net.errors.in.%s.%s", version, ip), errIn)
-			h.RecordCount(fmt.Sprintf("system.net.errors.out.%s.%s", version, ip), errOut)
-			h.RecordCount(fmt.Sprintf("system.net.errors.total.%s.%s", version, ip), errTotal)
-
-			h.RecordCount(fmt.Sprintf("system.net.dropped.in.%s.%s", version, ip), droppedIn)
-			h.RecordCount(fmt.Sprintf("system.net.dropped.out.%s.%s", version, ip), droppedOut)
-			h.RecordCount(fmt.Sprintf("system.net.dropped.total.%s.%s", version, ip), droppedTotal)
+			ipTag := metrics.PathTag("ip", ip)
+			h.RecordCount("system.net.bytes.sent."+version, bytesSent, ipTag)
+			h.RecordCount("system.net.bytes.recv."+version, bytesRecv, ipTag)
+			h.RecordCount("system.net.bytes.total."+version, bytesTotal, ipTag)
+
+			h.RecordCount("system.net.packets.sent."+version, packetsSent, ipTag)
+			h.RecordCount("system.net.packets.recv."+version, packetsRecv, ipTag)
+			h.RecordCount("system.net.packets.total."+version, packetsTotal, ipTag)
+
+			h.RecordCount("system.net.errors.in."+version, errIn, ipTag)
+			h.RecordCount("system.net.errors.out."+version, errOut, ipTag)
+			h.RecordCount("system.net.errors.total."+version, errTotal, ipTag)
+
+			h.RecordCount("system.net.dropped.in."+version, droppedIn, ipTag)
+			h.RecordCount("system.net.dropped.out."+version, droppedOut, ipTag)
+			h.RecordCount("system.net.dropped.total."+version, droppedTotal, ipTag)
 		}
 	}
 
@@ -289,7 +302,7 @@ func (h *HostCollector) collectDisk() error {
 	if err != nil {
 		return err
 	}
-	time.Sleep(1 * time.Second)
+	time.Sleep(sampleInterval)
 
 	stop, err := disk.DiskIOCounters()
 	if err != nil {
@@ -299,34 +312,36 @@ func (h *HostCollector) collectDisk() error {
 	for disk, stats := range start {
 		device := fmt.Sprintf("/dev/%s", disk)
 		mp := partByDevice[device].Mountpoint
+		mountTag := metrics.PathTag("mount", mp)
+		devTag := metrics.PathTag("device", disk)
 
 		read := int64(stop[disk].ReadBytes - stats.ReadBytes)
 		write := int64(stop[disk].WriteBytes - stats.WriteBytes)
 		total := read + write
 
 		if mp != "" {
-			h.RecordCount(fmt.Sprintf("system.disk.bytes.read.mount.%s.", mp), read)
-			h.RecordCount(fmt.Sprintf("system.disk.bytes.write.mount.%s", mp), write)
-			h.RecordCount(fmt.Sprintf("system.disk.bytes.total.mount.%s", mp), total)
+			h.RecordCount("system.disk.bytes.read.mount", read, mountTag)
+			h.RecordCount("system.disk.bytes.write.mount", write, mountTag)
+			h.RecordCount("system.disk.bytes.total.mount", total, mountTag)
 		}
 
-		h.RecordCount(fmt.Sprintf("system.disk.bytes.read.dev.%s.", disk), read)
-		h.RecordCount(fmt.Sprintf("system.disk.bytes.write.dev.%s", disk), write)
-		h.RecordCount(fmt.Sprintf("system.disk.bytes.total.dev.%s", disk), total)
+		h.RecordCount("system.disk.bytes.read.dev", read, devTag)
+		h.RecordCount("system.disk.bytes.write.dev", write, devTag)
+		h.RecordCount("system.disk.bytes.total.dev", total, devTag)
 
 		read = int64(stop[disk].ReadCount - stats.ReadCount)
 		write = int64(stop[disk].WriteCount - stats.WriteCount)
 		total = read + write
 
 		if mp != "" {
-			h.RecordCount(fmt.Sprintf("system.disk.iops.read.mount.%s", mp), read)
-			h.RecordCount(fmt.Sprintf("system.disk.iops.write.mount.%s", mp), write)
-			h.RecordCount(fmt.Sprintf("system.disk.iops.total.mount.%s", mp), total)
+			h.RecordCount("system.disk.iops.read.mount", read, mountTag)
+			h.RecordCount("system.disk.iops.write.mount", write, mountTag)
+			h.RecordCount("system.disk.iops.total.mount", total, mountTag)
 		}
 
-		h.RecordCount(fmt.Sprintf("system.disk.iops.read.dev.%s", disk), read)
-		h.RecordCount(fmt.Sprintf("system.disk.iops.write.dev.%s", disk), write)
-		h.RecordCount(fmt.Sprintf("system.disk.iops.total.dev.%s", disk), total)
+		h.RecordCount("system.disk.iops.read.dev", read, devTag)
+		h.RecordCount("system.disk.iops.write.dev", write, devTag)
+		h.RecordCount("system.disk.iops.total.dev", total, devTag)
 
 	}
 
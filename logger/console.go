@@ -23,6 +23,10 @@ func NewConsoleLogger(w io.Writer, formatter Formatter) (*ConsoleLogger, error)
 	}, nil
 }
 
+func (l *ConsoleLogger) String() string {
+	return "console"
+}
+
 func (l *ConsoleLogger) HandleLog(msg *docker.LogRecord) error {
 	line, err := l.formatter.Format(msg)
 	if err != nil {
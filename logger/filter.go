@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/metrics"
+)
+
+// Filter selects log records by container name, image, labels, stream and
+// message.  Containers, images and label values are glob patterns and
+// Message is a regular expression.  Empty fields match everything.
+type Filter struct {
+	Containers []string
+	Images     []string
+	Labels     map[string]string
+	Streams    []string
+	Message    *regexp.Regexp
+}
+
+func (f *Filter) Match(rec *docker.LogRecord) bool {
+	selector := docker.Selector{
+		Names:  f.Containers,
+		Images: f.Images,
+		Labels: f.Labels,
+	}
+	return selector.MatchNames(rec.ContainerName, rec.Image, rec.Labels) &&
+		f.matchStream(rec.Stream) &&
+		(f.Message == nil || f.Message.MatchString(rec.Message))
+}
+
+func (f *Filter) matchStream(stream string) bool {
+	if len(f.Streams) == 0 {
+		return true
+	}
+	for _, s := range f.Streams {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// Route is a named filter of the log records sent to a destination.
+type Route struct {
+	Name   string
+	Filter Filter
+}
+
+// FilteredLogger passes the log records matching one of its routes to a
+// handler and counts the records sent by each route.
+type FilteredLogger struct {
+	handler docker.LogHandler
+	routes  []Route
+}
+
+func NewFilteredLogger(handler docker.LogHandler, routes ...Route) *FilteredLogger {
+	return &FilteredLogger{
+		handler: handler,
+		routes:  routes,
+	}
+}
+
+func (l *FilteredLogger) String() string {
+	if s, ok := l.handler.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", l.handler)
+}
+
+func (l *FilteredLogger) Close() error {
+	if c, ok := l.handler.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (l *FilteredLogger) HandleLog(log *docker.LogRecord) error {
+	for _, route := range l.routes {
+		if route.Filter.Match(log) {
+			metrics.Self.RecordCount("hud.logs.routed", 1,
+				metrics.PathTag("destination", l.String()),
+				metrics.PathTag("route", route.Name))
+			return l.handler.HandleLog(log)
+		}
+	}
+	return nil
+}
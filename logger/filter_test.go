@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/jwilder/hud/docker"
+)
+
+type recordingHandler struct {
+	records []*docker.LogRecord
+}
+
+func (h *recordingHandler) HandleLog(rec *docker.LogRecord) error {
+	h.records = append(h.records, rec)
+	return nil
+}
+
+func TestFilteredLoggerRoutes(t *testing.T) {
+	h := &recordingHandler{}
+	l := NewFilteredLogger(h,
+		Route{
+			Name: "prod-errors",
+			Filter: Filter{
+				Labels:  map[string]string{"env": "prod*"},
+				Streams: []string{"stderr"},
+			},
+		},
+		Route{
+			Name: "panics",
+			Filter: Filter{
+				Images:  []string{"acme/*"},
+				Message: regexp.MustCompile(`^panic:`),
+			},
+		},
+	)
+
+	records := []*docker.LogRecord{
+		{ContainerName: "web", Labels: map[string]string{"env": "production"}, Stream: "stderr", Message: "oops"},
+		{ContainerName: "web", Labels: map[string]string{"env": "production"}, Stream: "stdout", Message: "ok"},
+		{ContainerName: "debug", Image: "acme/api", Stream: "stdout", Message: "panic: nil map"},
+		{ContainerName: "debug", Image: "acme/api", Stream: "stdout", Message: "ok"},
+		{ContainerName: "debug", Image: "busybox", Stream: "stderr", Message: "panic: nil map"},
+	}
+	for _, rec := range records {
+		l.HandleLog(rec)
+	}
+
+	if len(h.records) != 2 || h.records[0] != records[0] || h.records[1] != records[2] {
+		t.Fatalf("expected the first and third records to be routed, got %+v", h.records)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -39,23 +41,92 @@ type Formatter interface {
 
 type JSONFormatter struct{}
 
+// jsonKeys are the keys JSONFormatter writes for every record.
+var jsonKeys = []string{"time", "msg", "stream", "name", "id", "daemon", "truncated", "level"}
+
 func init() {
 	isTerminal = log.IsTerminal()
 	epoch = time.Now()
 }
 
-func miniTS() int {
-	return int(time.Since(epoch) / time.Second)
+// text returns the message of a record, or its fields as key=value pairs if
+// it was parsed and has no message.
+func text(rec *docker.LogRecord) string {
+	msg := strings.TrimRightFunc(rec.Message, unicode.IsSpace)
+	if msg == "" {
+		return FormatFields(rec.Fields)
+	}
+	return msg
+}
+
+// FormatFields returns fields as space separated key=value pairs sorted by
+// key.  Values with spaces or quotes are quoted.
+func FormatFields(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		switch v := fields[key].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				value = fmt.Sprint(v)
+			} else {
+				value = string(data)
+			}
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// miniTS returns the seconds between hud starting and ts.  Lines logged
+// before hud started have negative times.
+func miniTS(ts time.Time) int {
+	return int(ts.Sub(epoch) / time.Second)
 }
 
+// Format writes a record as a JSON object.  The parsed fields of the record
+// are merged into it; fields named like a key of the record are prefixed
+// with "fields.".
 func (f *JSONFormatter) Format(log *docker.LogRecord) ([]byte, error) {
 	data := map[string]interface{}{}
+	for key, value := range log.Fields {
+		data[key] = value
+	}
+	for _, key := range jsonKeys {
+		if value, ok := data[key]; ok {
+			delete(data, key)
+			data["fields."+key] = value
+		}
+	}
 
 	data["time"] = log.Ts.UTC().Format(StdDateFormat)
 	data["msg"] = log.Message
 	data["stream"] = log.Stream
 	data["name"] = log.ContainerName
 	data["id"] = log.ContainerID
+	if log.Daemon != "" {
+		data["daemon"] = log.Daemon
+	}
+	if log.Truncated {
+		data["truncated"] = true
+	}
+	if log.Level != "" {
+		data["level"] = log.Level
+	}
 
 	serialized, err := json.Marshal(data)
 	if err != nil {
@@ -66,40 +137,48 @@ func (f *JSONFormatter) Format(log *docker.LogRecord) ([]byte, error) {
 
 func (f *JSONFormatter) SetColored(colored bool) {}
 
+// ShortFormatter writes the container name and message of a record.  If
+// ShowDaemon is set, the container name is prefixed with its daemon.
 type ShortFormatter struct {
-	colored bool
+	colored    bool
+	ShowDaemon bool
 }
 
 func (f *ShortFormatter) SetColored(colored bool) {
 	f.colored = colored
 }
 
-func (f *ShortFormatter) hash(s string) uint32 {
+// ContainerColor returns the color a container name is shown in so each
+// container keeps the same color across views.
+func ContainerColor(name string) string {
 	h := fnv.New32a()
-	h.Write([]byte(s))
-	return h.Sum32()
+	h.Write([]byte(name))
+	return Colors[int(h.Sum32())%len(Colors)]
 }
 
 func (f *ShortFormatter) Format(rec *docker.LogRecord) ([]byte, error) {
-	msg := strings.TrimRightFunc(rec.Message, unicode.IsSpace)
+	msg := text(rec)
 
 	if msg == "" {
 		return nil, nil
 	}
 
-	if isTerminal && f.colored {
-		color := int(f.hash(rec.ContainerName)) % len(Colors)
+	name := rec.ContainerName
+	if f.ShowDaemon && rec.Daemon != "" {
+		name = rec.Daemon + "/" + name
+	}
 
+	if isTerminal && f.colored {
 		return []byte(fmt.Sprintf("%s %s: %s\x1b[0m\n",
-			f.colorize(fmt.Sprintf("[%04d]", miniTS()), ansi.ColorWhite),
-			f.colorize(rec.ContainerName, Colors[color]),
+			f.colorize(fmt.Sprintf("[%04d]", miniTS(rec.Ts)), ansi.ColorWhite),
+			f.colorize(name, ContainerColor(name)),
 			string(ansi.StripAnsiControl([]byte(msg))))), nil
 
 	}
 
 	return []byte(fmt.Sprintf("[%04d] %s: %s\x1b[0m\n",
-		miniTS(),
-		rec.ContainerName,
+		miniTS(rec.Ts),
+		name,
 		string(ansi.StripAnsi([]byte(msg))))), nil
 }
 
@@ -107,8 +186,11 @@ func (f *ShortFormatter) colorize(text, color string) string {
 	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, text)
 }
 
+// ExtendedFormatter writes records as key=value pairs.  If ShowDaemon is
+// set, the daemon of the container is written after its name.
 type ExtendedFormatter struct {
-	colored bool
+	colored    bool
+	ShowDaemon bool
 }
 
 func (f *ExtendedFormatter) SetColored(colored bool) {
@@ -121,38 +203,59 @@ func (f *ExtendedFormatter) hash(s string) uint32 {
 	return h.Sum32()
 }
 
+// Format writes the time, container and message of a record followed by
+// its level and parsed fields as key=value pairs.
 func (f *ExtendedFormatter) Format(rec *docker.LogRecord) ([]byte, error) {
 	msg := strings.TrimRightFunc(rec.Message, unicode.IsSpace)
 
-	if msg == "" {
+	if msg == "" && len(rec.Fields) == 0 {
 		return nil, nil
 	}
 
+	container := rec.ContainerName
+	if f.ShowDaemon && rec.Daemon != "" {
+		container += " daemon=" + rec.Daemon
+	}
+
+	var extra string
+	if rec.Level != "" {
+		extra += " level=" + rec.Level
+	}
+	if len(rec.Fields) > 0 {
+		extra += " " + FormatFields(rec.Fields)
+	}
+
 	if isTerminal && f.colored {
 		color := int(f.hash(rec.ContainerName)) % len(Colors)
 
-		return []byte(fmt.Sprintf("%-24s %s msg=\"%s\"\x1b[0m\n",
+		return []byte(fmt.Sprintf("%-24s %s msg=\"%s\"%s\x1b[0m\n",
 			f.colorize(rec.Ts.UTC().Format(StdDateFormat), ansi.ColorWhite),
-			f.colorize("container="+rec.ContainerName, Colors[color]),
-			string(ansi.StripAnsiControl([]byte(msg))))), nil
+			f.colorize("container="+container, Colors[color]),
+			string(ansi.StripAnsiControl([]byte(msg))),
+			string(ansi.StripAnsiControl([]byte(extra))))), nil
 	}
 
-	return []byte(fmt.Sprintf("%-24s container=%s msg=\"%s\"\n",
+	return []byte(fmt.Sprintf("%-24s container=%s msg=\"%s\"%s\n",
 		rec.Ts.UTC().Format(StdDateFormat),
-		rec.ContainerName,
-		string(ansi.StripAnsi([]byte(msg))))), nil
+		container,
+		string(ansi.StripAnsi([]byte(msg))),
+		string(ansi.StripAnsi([]byte(extra))))), nil
 }
 
 func (f *ExtendedFormatter) colorize(text, color string) string {
 	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, text)
 }
 
+// SyslogFormatter writes records as RFC 5424 syslog messages tagged with
+// the container name.  If ShowDaemon is set, the daemon of the container is
+// sent as the HOSTNAME instead of Hostname.
 type SyslogFormatter struct {
-	colored  bool
-	Facility Priority
-	Severity Priority
-	Hostname string
-	Newline  bool
+	colored    bool
+	Facility   Priority
+	Severity   Priority
+	Hostname   string
+	Newline    bool
+	ShowDaemon bool
 }
 
 func (f *SyslogFormatter) SetColored(colored bool) {
@@ -163,7 +266,7 @@ func (f *SyslogFormatter) priority() Priority {
 }
 
 func (f *SyslogFormatter) Format(rec *docker.LogRecord) ([]byte, error) {
-	msg := strings.Replace(rec.Message, "\n", " ", -1)
+	msg := strings.Replace(text(rec), "\n", " ", -1)
 	msg = strings.Replace(msg, "\r", " ", -1)
 	msg = strings.Replace(msg, "\x00", " ", -1)
 
@@ -173,8 +276,12 @@ func (f *SyslogFormatter) Format(rec *docker.LogRecord) ([]byte, error) {
 
 	ts := rec.Ts.Format(Rfc5424DateFormat)
 	tag := rec.ContainerName
+	hostname := f.Hostname
+	if f.ShowDaemon && rec.Daemon != "" {
+		hostname = rec.Daemon
+	}
 
-	buf := fmt.Sprintf("<%d>1 %s %s %s - - - %s", f.priority(), ts, f.Hostname, tag, msg)
+	buf := fmt.Sprintf("<%d>1 %s %s %s - - - %s", f.priority(), ts, hostname, tag, msg)
 	if f.Newline {
 		return []byte(buf + "\n"), nil
 	}
@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jwilder/hud/docker"
+)
+
+func TestFormattersUseLogTime(t *testing.T) {
+	ts := time.Date(2016, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	rec := &docker.LogRecord{
+		Ts:            ts,
+		Received:      ts.Add(time.Minute),
+		ContainerName: "web",
+		Stream:        "stdout",
+		Message:       "hello\n",
+	}
+
+	for _, test := range []struct {
+		formatter Formatter
+		expected  string
+	}{
+		{&JSONFormatter{}, `"time":"2016-01-02T03:04:05.006Z"`},
+		{&ExtendedFormatter{}, "2016-01-02T03:04:05.006Z container=web"},
+		{&SyslogFormatter{Hostname: "host"}, "<0>1 2016-01-02T03:04:05.006Z host web"},
+		{&ShortFormatter{}, fmt.Sprintf("[%04d] web: hello", miniTS(ts))},
+	} {
+		line, err := test.formatter.Format(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(line), test.expected) {
+			t.Errorf("%T: expected %q in %q", test.formatter, test.expected, line)
+		}
+	}
+}
+
+func TestFormattersShowDaemon(t *testing.T) {
+	ts := time.Date(2016, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	rec := &docker.LogRecord{
+		Ts:            ts,
+		Daemon:        "node1",
+		ContainerName: "web",
+		Stream:        "stdout",
+		Message:       "hello\n",
+	}
+
+	for _, test := range []struct {
+		formatter Formatter
+		expected  string
+	}{
+		{&ExtendedFormatter{ShowDaemon: true}, "container=web daemon=node1 "},
+		{&SyslogFormatter{Hostname: "host", ShowDaemon: true}, "<0>1 2016-01-02T03:04:05.006Z node1 web"},
+		{&ShortFormatter{ShowDaemon: true}, fmt.Sprintf("[%04d] node1/web: hello", miniTS(ts))},
+		{&ShortFormatter{}, fmt.Sprintf("[%04d] web: hello", miniTS(ts))},
+	} {
+		line, err := test.formatter.Format(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(line), test.expected) {
+			t.Errorf("%T: expected %q in %q", test.formatter, test.expected, line)
+		}
+	}
+}
+
+func TestFormattersUseFields(t *testing.T) {
+	rec := &docker.LogRecord{
+		Ts:            time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		ContainerName: "api",
+		Stream:        "stdout",
+		Message:       "request done\n",
+		Level:         "info",
+		Fields: map[string]interface{}{
+			"status":   json.Number("200"),
+			"path":     "/orders",
+			"user":     "jane doe",
+			"name":     "checkout",
+			"duration": 1.5,
+		},
+	}
+
+	line, err := (&JSONFormatter{}).Format(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(line, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data["status"] != 200.0 || data["path"] != "/orders" || data["level"] != "info" ||
+		data["name"] != "api" || data["fields.name"] != "checkout" || data["msg"] != "request done\n" {
+		t.Fatalf("expected fields to be merged, got %s", line)
+	}
+
+	line, err = (&ExtendedFormatter{}).Format(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `container=api msg="request done" level=info duration=1.5 name=checkout path=/orders status=200 user="jane doe"`
+	if !strings.Contains(string(line), expected) {
+		t.Fatalf("expected %q in %q", expected, line)
+	}
+
+	rec.Message = ""
+	line, err = (&ShortFormatter{}).Format(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(line), "api: duration=1.5 name=checkout") {
+		t.Fatalf("expected fields without a message to be shown, got %q", line)
+	}
+}
@@ -7,27 +7,36 @@ package logger
 
 import (
 	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/metrics"
 
 	"net/url"
 	log "github.com/Sirupsen/logrus"
 )
 
+// errClosed is returned by HandleLog once the logger is closed.
+var errClosed = errors.New("logger closed")
+
 // A Logger is a connection to a syslog server. It reconnects on error.
 // Clients log by sending a Packet to the logger.Packets channel.
 type SocketLogger struct {
+	sync.Mutex
 	conn      net.Conn
+	done      chan struct{}
+	closed    bool
+	failed    bool
 	proto     string
 	raddr     string
-	rootCAs   *x509.CertPool
+	tlsConfig *tls.Config
 	formatter Formatter
 }
 
-func NewSocketLogger(dest string, rootCAs *x509.CertPool, formatter Formatter) (*SocketLogger, error) {
+func NewSocketLogger(dest string, tlsConfig *tls.Config, formatter Formatter) (*SocketLogger, error) {
 	u, err := url.Parse(dest)
 	if err != nil {
 		return nil, err
@@ -39,16 +48,17 @@ func NewSocketLogger(dest string, rootCAs *x509.CertPool, formatter Formatter) (
 	}
 
 	// dial once, just to make sure the network is working
-	//conn, err := dial(proto, u.Host, rootCAs)
+	//conn, err := dial(proto, u.Host, tlsConfig)
 
 	if err != nil {
 		return nil, err
 	}
 	logger := &SocketLogger{
-		proto:   proto,
-		raddr:   u.Host,
-		rootCAs: rootCAs,
+		proto:     proto,
+		raddr:     u.Host,
+		tlsConfig: tlsConfig,
 		//conn:      conn,
+		done:      make(chan struct{}),
 		formatter: formatter,
 	}
 	return logger, nil
@@ -56,17 +66,13 @@ func NewSocketLogger(dest string, rootCAs *x509.CertPool, formatter Formatter) (
 }
 
 // dial connects to the server and set up a watching goroutine
-func dial(proto, raddr string, rootCAs *x509.CertPool) (net.Conn, error) {
+func dial(proto, raddr string, tlsConfig *tls.Config) (net.Conn, error) {
 	var netConn net.Conn
 	var err error
 
 	switch proto {
 	case "tls":
-		var config *tls.Config
-		if rootCAs != nil {
-			config = &tls.Config{RootCAs: rootCAs}
-		}
-		netConn, err = tls.Dial("tcp", raddr, config)
+		netConn, err = tls.Dial("tcp", raddr, tlsConfig)
 	case "udp", "tcp":
 		netConn, err = net.Dial(proto, raddr)
 	default:
@@ -78,18 +84,67 @@ func dial(proto, raddr string, rootCAs *x509.CertPool) (net.Conn, error) {
 	return netConn, nil
 }
 
-// Connect to the server, retrying every 10 seconds until successful.
-func (l *SocketLogger) connect() {
+// Connect to the server, retrying every 10 seconds until successful or the
+// logger is closed.  Connecting again after a failed write or dial counts
+// as a reconnect.
+func (l *SocketLogger) connect() (net.Conn, error) {
 	for {
-		c, err := dial(l.proto, l.raddr, l.rootCAs)
+		if l.failed {
+			metrics.Self.RecordCount("hud.reconnects", 1, metrics.PathTag("component", "logger"), metrics.PathTag("destination", l.String()))
+		}
+		c, err := dial(l.proto, l.raddr, l.tlsConfig)
 		if err == nil {
-			l.conn = c
-			return
-		} else {
-			log.Errorf("ERROR: %s", err)
-			time.Sleep(10 * time.Second)
+			l.failed = false
+			return c, nil
+		}
+		l.failed = true
+		log.Errorf("ERROR: %s", err)
+		select {
+		case <-time.After(10 * time.Second):
+		case <-l.done:
+			return nil, errClosed
+		}
+	}
+}
+
+// Close closes the connection of the logger and stops it from reconnecting.
+// It can be called while a log is being handled.
+func (l *SocketLogger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	if l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+// setConn replaces the connection of the logger.  It returns false and
+// closes conn if the logger was closed meanwhile.
+func (l *SocketLogger) setConn(conn net.Conn) bool {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		if conn != nil {
+			conn.Close()
 		}
+		return false
 	}
+	if conn == nil && l.conn != nil {
+		l.conn.Close()
+	}
+	l.conn = conn
+	return true
+}
+
+func (l *SocketLogger) String() string {
+	return l.proto + "://" + l.raddr
 }
 
 func (l *SocketLogger) HandleLog(log *docker.LogRecord) error {
@@ -103,27 +158,36 @@ func (l *SocketLogger) HandleLog(log *docker.LogRecord) error {
 		return nil
 	}
 
-	if l.conn == nil {
-		l.connect()
+	l.Lock()
+	conn := l.conn
+	l.Unlock()
+	if conn == nil {
+		conn, err = l.connect()
+		if err != nil {
+			return err
+		}
+		if !l.setConn(conn) {
+			return errClosed
+		}
 	}
 
 	var n int
-	switch l.conn.(type) {
+	switch conn.(type) {
 	case *net.TCPConn, *tls.Conn, *net.UDPConn:
-		n, err = l.conn.Write(line)
+		n, err = conn.Write(line)
 	default:
 		panic(fmt.Errorf("Network protocol %s not supported", l.proto))
 	}
 
 	if err != nil {
-		l.conn.Close()
-		l.conn = nil
+		l.setConn(nil)
+		l.failed = true
 		return err
 	}
 
 	if n != len(line) {
-		l.conn.Close()
-		l.conn = nil
+		l.setConn(nil)
+		l.failed = true
 		return fmt.Errorf("short read. expect %d. got %d", n, len(line))
 	}
 
@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jwilder/hud/docker"
+)
+
+func TestSocketLoggerCloseStopsReconnecting(t *testing.T) {
+	// a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	logger, err := NewSocketLogger("tcp://"+addr, nil, &ShortFormatter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error)
+	go func() {
+		errs <- logger.HandleLog(&docker.LogRecord{Ts: time.Now(), Message: "hello\n"})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	logger.Close()
+	select {
+	case err := <-errs:
+		if err != errClosed {
+			t.Fatalf("expected the logger to be closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the logger to stop reconnecting")
+	}
+}
@@ -1,47 +1,95 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
 	log "github.com/Sirupsen/logrus"
+	"github.com/jwilder/hud/config"
 	"github.com/jwilder/hud/docker"
 	"github.com/jwilder/hud/host"
 	"github.com/jwilder/hud/logger"
 	"github.com/jwilder/hud/metrics"
+	"github.com/jwilder/hud/top"
 )
 
+// topInterval is the default flush interval of hud top.
+const topInterval = 2
+
 var (
+	buildVersion    string
+	httpClient      *http.Client
+	wg              sync.WaitGroup
+	logDestinations []logDestination
+
+	// opts are the settings hud was started with.  They are not changed
+	// after startup: reloads build their own settings.
+	opts settings
+)
+
+// settings are the values of the flags, overridden by the config file for
+// flags not set on the command line.
+type settings struct {
+	configFile      string
+	dockerEndpoints sliceVar
+	dockerTLS       docker.TLSConfig
+	stateFile       string
+	maxLineSize     int
 	statsPrefix     string
 	debug           bool
 	version         bool
-	buildVersion    string
 	influxDBAddr    string
 	influxDBUser    string
 	influxDBPass    string
 	influxDBDB      string
+	influxDBRP      string
+	influxDBOrg     string
+	influxDBBucket  string
+	influxDBToken   string
+	influxDBPrec    string
+	influxDBGzip    bool
 	graphiteAddr    string
+	prometheusAddr  string
+	httpAddr        string
+	shutdownTimeout time.Duration
+	statsdAddr      string
+	statsdMTU       int
+	statsdTags      bool
+	flatNames       bool
+	spoolDir        string
+	spoolMaxSize    int
 	hostname        string
 	noStats         bool
 	flushInterval   int
-	httpClient      *http.Client
 	logDests        sliceVar
 	logFmts         sliceVar
 	noLogs          bool
-	wg              sync.WaitGroup
-	logDestinations []logDestination
-)
+}
 
+// logDestination is a parsed log destination.  Destinations with the same
+// key have the same settings.
 type logDestination struct {
-	dest   string
-	format string
+	key       string
+	dest      string
+	format    string
+	facility  logger.Priority
+	severity  logger.Priority
+	tlsConfig *tls.Config
+	routes    []logger.Route
 }
 
 type sliceVar []string
@@ -62,8 +110,9 @@ func parseLogDestinations(logDests, logFmts sliceVar) ([]logDestination, error)
 
 	dests := []logDestination{}
 	for _, dest := range logDests {
+		conditions := strings.Split(dest, ",")
 		format := "short"
-		parts := strings.Split(dest, "=")
+		parts := strings.Split(conditions[0], "=")
 		if len(parts) == 2 {
 			format = parts[1]
 		}
@@ -81,46 +130,330 @@ func parseLogDestinations(logDests, logFmts sliceVar) ([]logDestination, error)
 			}
 		}
 
-		dests = append(dests,
-			logDestination{
-				dest:   addr,
-				format: format,
+		d := logDestination{
+			key:      dest,
+			dest:     addr,
+			format:   format,
+			facility: logger.LogLocal1,
+			severity: logger.SevInfo,
+		}
+		if len(conditions) > 1 {
+			route, err := parseRoute(conditions[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", dest, err)
+			}
+			d.routes = []logger.Route{route}
+		}
+		dests = append(dests, d)
+	}
+	return dests, nil
+}
+
+// parseRoute returns the route of the key=value conditions following a
+// -log-to destination: container, image, label.<name>, stream and message.
+// Repeated keys match any of their values.
+func parseRoute(conditions []string) (logger.Route, error) {
+	route := logger.Route{Name: "log-to"}
+	f := &route.Filter
+	for _, c := range conditions {
+		kv := strings.SplitN(c, "=", 2)
+		if len(kv) != 2 {
+			return route, fmt.Errorf("bad route condition %q, expected key=value", c)
+		}
+		key, value := kv[0], kv[1]
+		switch {
+		case key == "container":
+			f.Containers = append(f.Containers, value)
+		case key == "image":
+			f.Images = append(f.Images, value)
+		case strings.HasPrefix(key, "label."):
+			if f.Labels == nil {
+				f.Labels = map[string]string{}
+			}
+			f.Labels[strings.TrimPrefix(key, "label.")] = value
+		case key == "stream":
+			if value != "stdout" && value != "stderr" {
+				return route, fmt.Errorf("unknown stream %q, expected stdout or stderr", value)
+			}
+			f.Streams = append(f.Streams, value)
+		case key == "message":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return route, err
+			}
+			f.Message = re
+		default:
+			return route, fmt.Errorf("unknown route condition %q", key)
+		}
+	}
+
+	selector := docker.Selector{Names: f.Containers, Images: f.Images, Labels: f.Labels}
+	return route, selector.Validate()
+}
+
+// configLogDestinations returns the log destinations of a config file.  The
+// config has already been validated.
+func configLogDestinations(cfg *config.Config) ([]logDestination, error) {
+	dests := []logDestination{}
+	for _, d := range cfg.Logs {
+		dest := logDestination{
+			key:      fmt.Sprintf("%+v", d),
+			dest:     d.Dest,
+			format:   d.Format,
+			facility: logger.LogLocal1,
+			severity: logger.SevInfo,
+		}
+		if len(d.Filter.Containers) > 0 || len(d.Filter.Streams) > 0 {
+			dest.routes = append(dest.routes, logger.Route{
+				Name: "filter",
+				Filter: logger.Filter{
+					Containers: d.Filter.Containers,
+					Streams:    d.Filter.Streams,
+				},
 			})
+		}
+		for i, r := range d.Routes {
+			route := logger.Route{
+				Name: r.RouteName(i),
+				Filter: logger.Filter{
+					Containers: r.Containers,
+					Images:     r.Images,
+					Labels:     r.Labels,
+					Streams:    r.Streams,
+				},
+			}
+			if r.Message != "" {
+				route.Filter.Message = regexp.MustCompile(r.Message)
+			}
+			dest.routes = append(dest.routes, route)
+		}
+		if dest.format == "" {
+			dest.format = "short"
+		}
+		if d.Facility != "" {
+			dest.facility, _ = logger.Facility(d.Facility)
+		}
+		if d.Severity != "" {
+			dest.severity, _ = logger.Severity(d.Severity)
+		}
+		if d.TLS != (config.TLSConfig{}) {
+			tlsConfig, err := d.TLS.ClientConfig()
+			if err != nil {
+				return nil, err
+			}
+			dest.tlsConfig = tlsConfig
+		}
+		dests = append(dests, dest)
 	}
 	return dests, nil
 }
 
+// daemon is a docker daemon to watch.
+type daemon struct {
+	name     string
+	endpoint string
+}
+
+// parseDaemons returns the daemons of the -docker-endpoint flags, given as
+// endpoint[=name], or of the config file.  Without either, the daemon at
+// DOCKER_HOST or the local socket is watched.
+func parseDaemons(endpoints sliceVar, cfg *config.Config) ([]daemon, error) {
+	daemons := []daemon{}
+	if len(endpoints) > 0 {
+		for _, endpoint := range endpoints {
+			parts := strings.SplitN(endpoint, "=", 2)
+			d := daemon{endpoint: parts[0]}
+			if len(parts) == 2 {
+				d.name = parts[1]
+			}
+			daemons = append(daemons, d)
+		}
+	} else if cfg != nil {
+		if cfg.Docker.Endpoint != "" {
+			daemons = append(daemons, daemon{endpoint: cfg.Docker.Endpoint})
+		}
+		for _, d := range cfg.Docker.Endpoints {
+			daemons = append(daemons, daemon{name: d.Name, endpoint: d.Endpoint})
+		}
+	}
+	if len(daemons) == 0 {
+		daemons = append(daemons, daemon{})
+	}
+
+	names := map[string]bool{}
+	for i, d := range daemons {
+		endpoint, err := docker.GetEndpoint(d.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		daemons[i].endpoint = endpoint
+		if d.name == "" {
+			daemons[i].name = daemonName(endpoint)
+		}
+
+		if names[daemons[i].name] {
+			return nil, fmt.Errorf("duplicate daemon name %s, name it with %s=name", daemons[i].name, endpoint)
+		}
+		names[daemons[i].name] = true
+	}
+	return daemons, nil
+}
+
+// daemonName returns the default name of a daemon: the hostname for local
+// daemons, otherwise the host of its endpoint.
+func daemonName(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "unix" || u.Hostname() == "" {
+		return opts.hostname
+	}
+	return u.Hostname()
+}
+
+// restartSettings returns the settings of the config file that are only
+// read at startup, to warn when a reload changes them.
+func restartSettings(cfg *config.Config) string {
+	if cfg == nil {
+		return ""
+	}
+	return fmt.Sprint(cfg.Containers, cfg.Multiline, cfg.LogRules, cfg.Redact, cfg.RateLimits, cfg.Parse)
+}
+
+// define defines the flags of the settings on fs.
+func (s *settings) define(fs *flag.FlagSet) {
+	fs.StringVar(&s.configFile, "config", "", "Load settings from a YAML or TOML file.  Flags override file settings.")
+	fs.StringVar(&s.statsPrefix, "prefix", "", "Global prefix for all stats")
+	fs.BoolVar(&s.debug, "debug", false, "Enables debug logging")
+	fs.BoolVar(&s.version, "v", false, "Display version info")
+	fs.BoolVar(&s.noLogs, "no-logs", false, "Disable log tailing")
+	fs.BoolVar(&s.noStats, "no-stats", false, "Disable stats collection")
+	fs.IntVar(&s.flushInterval, "flush-interval", 60, "Flush metrics every interval seconds")
+
+	certPath := os.Getenv("DOCKER_CERT_PATH")
+	if certPath == "" {
+		certPath = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+	fs.Var(&s.dockerEndpoints, "docker-endpoint", "Docker API endpoint and daemon name [unix|tcp]://...[=name].  Repeat to watch several daemons.  Container cpu and memory metrics are only collected for local daemons. (default $DOCKER_HOST or unix:///var/run/docker.sock)")
+	fs.BoolVar(&s.dockerTLS.Verify, "tlsverify", os.Getenv("DOCKER_TLS_VERIFY") != "", "Use TLS and verify the docker daemon certificate")
+	fs.StringVar(&s.dockerTLS.CACert, "tlscacert", filepath.Join(certPath, "ca.pem"), "Trust docker daemon certificates signed by this CA")
+	fs.StringVar(&s.dockerTLS.Cert, "tlscert", filepath.Join(certPath, "cert.pem"), "TLS client certificate for the docker daemon")
+	fs.StringVar(&s.dockerTLS.Key, "tlskey", filepath.Join(certPath, "key.pem"), "TLS client key for the docker daemon")
+
+	fs.StringVar(&s.stateFile, "state-file", "", "Save the last log line seen of each container to this file to resume tailing after a restart")
+	fs.IntVar(&s.maxLineSize, "max-line-size", docker.DefaultMaxLineSize, "Truncate log lines longer than this many bytes")
+
+	fs.StringVar(&s.influxDBAddr, "influxdb-addr", "", "InfluxDB URL (http://host:8086)")
+	fs.StringVar(&s.influxDBUser, "influxdb-user", "", "InfluxDB v1 username")
+	fs.StringVar(&s.influxDBPass, "influxdb-pass", "", "InfluxDB v1 password")
+	fs.StringVar(&s.influxDBDB, "influxdb-db", "", "InfluxDB v1 database")
+	fs.StringVar(&s.influxDBRP, "influxdb-rp", "", "InfluxDB v1 retention policy")
+	fs.StringVar(&s.influxDBOrg, "influxdb-org", "", "InfluxDB v2 organization")
+	fs.StringVar(&s.influxDBBucket, "influxdb-bucket", "", "InfluxDB v2 bucket")
+	fs.StringVar(&s.influxDBToken, "influxdb-token", "", "InfluxDB v2 API token")
+	fs.StringVar(&s.influxDBPrec, "influxdb-precision", "s", "InfluxDB timestamp precision [ns, us, ms, s]")
+	fs.BoolVar(&s.influxDBGzip, "influxdb-gzip", false, "Gzip InfluxDB write requests")
+	fs.StringVar(&s.graphiteAddr, "graphite-addr", "", "Graphite host:port")
+	fs.StringVar(&s.statsdAddr, "statsd-addr", "", "StatsD host:port")
+	fs.IntVar(&s.statsdMTU, "statsd-mtu", metrics.DefaultStatsDMTU, "Maximum StatsD packet size in bytes")
+	fs.BoolVar(&s.statsdTags, "statsd-tags", false, "Send metric tags to StatsD using the DogStatsD format")
+	fs.StringVar(&s.spoolDir, "spool-dir", "", "Spool graphite and influxdb metrics to this directory while the sinks are unavailable")
+	fs.IntVar(&s.spoolMaxSize, "spool-max-size", 100, "Maximum size of the metrics spool per sink in MB")
+	fs.BoolVar(&s.flatNames, "flat-names", false, "Encode metric tags in legacy flat metric names instead of sending them as tags")
+	fs.StringVar(&s.prometheusAddr, "prometheus-addr", "", "Serve Prometheus metrics on host:port")
+	fs.StringVar(&s.httpAddr, "http-addr", "", "Serve the hud API on host:port: GET /metrics, /containers, /events and /logs, POST /reload")
+	fs.DurationVar(&s.shutdownTimeout, "shutdown-timeout", 10*time.Second, "Time allowed to drain logs and flush metrics on shutdown")
+	fs.StringVar(&s.hostname, "hostname", "", "Hostname of this host for remote logging systems")
+	fs.Var(&s.logDests, "log-to", "Log destination, format and route [console, [tcp|udp|tls://]host:port][=short,ext,json,syslog][,container=glob,image=glob,label.name=glob,stream=stdout|stderr,message=regexp]. (default console)")
+}
+
+// loadSettings parses the command line arguments and sets the flags that
+// they do not set from the config file.  cfg may be nil.
+func loadSettings(args []string, cfg *config.Config) (settings, error) {
+	var s settings
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	s.define(fs)
+	if err := fs.Parse(args); err != nil {
+		return s, err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if cfg != nil {
+		for name, value := range cfg.Flags() {
+			if set[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				return s, fmt.Errorf("%s: %s", name, err)
+			}
+			set[name] = true
+		}
+	}
+
+	// like the docker client, certificates found in the default paths do
+	// not turn TLS on
+	s.dockerTLS.Enabled = set["tlscacert"] || set["tlscert"] || set["tlskey"]
+	return s, nil
+}
+
 func main() {
-	flag.StringVar(&statsPrefix, "prefix", "", "Global prefix for all stats")
-	flag.BoolVar(&debug, "debug", false, "Enables debug logging")
-	flag.BoolVar(&version, "v", false, "Display version info")
-	flag.BoolVar(&noLogs, "no-logs", false, "Disable log tailing")
-	flag.BoolVar(&noStats, "no-stats", false, "Disable stats collection")
-	flag.IntVar(&flushInterval, "flush-interval", 60, "Flush metrics every interval seconds")
-
-	flag.StringVar(&influxDBAddr, "influxdb-addr", "", "InfluxDB host:port")
-	flag.StringVar(&influxDBUser, "influxdb-user", "", "InfluxDB username")
-	flag.StringVar(&influxDBPass, "influxdb-pass", "", "InfluxDB password")
-	flag.StringVar(&influxDBDB, "influxdb-db", "", "InfluxDB database")
-	flag.StringVar(&graphiteAddr, "graphite-addr", "", "Graphite host:port")
-	flag.StringVar(&hostname, "hostname", "", "Hostname of this host for remote logging systems")
-	flag.Var(&logDests, "log-to", "Log destination and format [console, [tcp|udp|tls://]host:port][=short,ext,json,syslog]. (default console)")
+	opts.define(flag.CommandLine)
 
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [top]\n\n", os.Args[0])
+		fmt.Fprint(os.Stderr, "With top, show a live dashboard of the containers and their logs instead of forwarding them.\n\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	topMode := flag.Arg(0) == "top"
 
-	if version {
+	if opts.version {
 		fmt.Println(buildVersion)
 		return
 	}
 
 	log.SetOutput(os.Stderr)
 
-	endpoint, err := docker.GetEndpoint()
+	out := newOutputs()
+	var cfg *config.Config
+	var err error
+	if opts.configFile != "" {
+		cfg, err = config.Load(opts.configFile)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+	opts, err = loadSettings(os.Args[1:], cfg)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	if topMode {
+		// the dashboard owns the terminal
+		if !out.cliFlags["flush-interval"] {
+			opts.flushInterval = topInterval
+		}
+		opts.noStats = false
+		log.SetOutput(ioutil.Discard)
+	}
+
+	metrics.Self.Prefix = opts.statsPrefix
+
+	if opts.hostname == "" {
+		opts.hostname, err = os.Hostname()
+		if err != nil {
+			log.Fatalf("ERROR: Unable to lookup hostname: %s", err)
+		}
+	}
+
+	daemons, err := parseDaemons(opts.dockerEndpoints, cfg)
 	if err != nil {
 		log.Fatalf("Bad docker endpoint: %s", err)
 	}
 
-	if debug {
+	if opts.debug {
 		log.Debug("Debug enabled")
 		log.SetLevel(log.DebugLevel)
 		go func() {
@@ -128,94 +461,198 @@ func main() {
 		}()
 	}
 
-	if graphiteAddr != "" && !noStats {
-		log.Infof("Sending metrics to graphite at %s", graphiteAddr)
-		g, err := metrics.NewGraphite(graphiteAddr, 3*time.Second)
-		if err != nil {
-			log.Fatalf("ERROR: %s", err)
-		}
+	state, err := docker.NewLogState(opts.stateFile)
+	if err != nil {
+		log.Fatalf("ERROR: Unable to read state file: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 
-		metrics.AddHandler(g)
+	var filter *docker.ContainerFilter
+	multiline := []*docker.MultilineRule{}
+	rules := []*docker.LogRule{}
+	redactions := []*docker.Redaction{}
+	limits := []*docker.RateLimit{}
+	parse := []*docker.ParseRule{}
+	if cfg != nil {
+		filter = cfg.Containers.Filter()
+		for _, m := range cfg.Multiline {
+			multiline = append(multiline, m.Rule())
+		}
+		for i, r := range cfg.LogRules {
+			rules = append(rules, r.Rule(i))
+		}
+		for _, r := range cfg.Redact {
+			redactions = append(redactions, r.Redaction())
+		}
+		for _, r := range cfg.RateLimits {
+			limits = append(limits, r.Limit())
+		}
+		for _, p := range cfg.Parse {
+			parse = append(parse, p.Rule())
+		}
 	}
 
-	if influxDBAddr != "" && !noStats {
-		log.Infof("Sending metrics to influxdb at %s", influxDBAddr)
-		i, err := metrics.NewInfluxDB(influxDBUser, influxDBPass, influxDBAddr, influxDBDB)
-		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+	// the daemon is part of flat metric names only if it is needed to
+	// tell containers apart
+	collectors := []*docker.DockerCollector{}
+	for _, d := range daemons {
+		tag := metrics.NewTag("daemon", d.name)
+		if len(daemons) > 1 {
+			tag = metrics.PathTag("daemon", d.name)
+		}
+		broadcaster := &docker.Broadcaster{
+			Endpoint: d.endpoint,
+			TLS:      opts.dockerTLS,
+			Daemon:   tag,
+			Filter:   filter,
 		}
+		tailer := &docker.Tailer{
+			State:       state,
+			Multiline:   multiline,
+			Rules:       rules,
+			Redactions:  redactions,
+			RateLimits:  limits,
+			Parse:       parse,
+			MaxLineSize: opts.maxLineSize,
+		}
+		collectors = append(collectors, docker.NewDockerCollector(ctx, opts.statsPrefix, broadcaster, tailer, opts.flushInterval))
+	}
+	dockerC := docker.NewCollectors(collectors...)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		state.SaveForever(ctx, 5*time.Second)
+	}()
+	if !opts.noStats {
+		hostC := host.NewHostCollector(opts.statsPrefix, opts.flushInterval)
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			metrics.FlushPeriodically(ctx, opts.flushInterval)
+		}()
+		go func() {
+			defer wg.Done()
+			metrics.CollectRuntimeForever(ctx, opts.flushInterval)
+		}()
+		go func() {
+			defer wg.Done()
+			hostC.CollectForever(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			dockerC.CollectForever(ctx)
+		}()
+	}
+
+	if topMode {
+		runTop(ctx, cancel, dockerC, state)
+		return
+	}
 
-		metrics.AddHandler(i)
+	out.dockerC = dockerC
+	out.daemons = daemons
+	out.restart = restartSettings(cfg)
+	if err := out.Apply(cfg, opts); err != nil {
+		log.Fatalf("ERROR: %s", err)
 	}
 
-	broadcaster := &docker.Broadcaster{
-		Endpoint: endpoint,
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := out.Reload(); err != nil {
+				log.Errorf("ERROR: Unable to reload: %s", err)
+			}
+		}
+	}()
+
+	if opts.httpAddr != "" {
+		log.Infof("Serving hud API at %s", opts.httpAddr)
+		mux := http.NewServeMux()
+		mux.Handle("/reload", out)
+		a := newAPI(dockerC)
+		a.register(mux)
+		if !opts.noStats {
+			metrics.AddHandler(a)
+		}
+		go func() {
+			log.Fatalf("ERROR: %s", http.ListenAndServe(opts.httpAddr, mux))
+		}()
 	}
+
 	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dockerC.WatchForever(ctx)
+	}()
 
-	dockerC := docker.NewDockerCollector(statsPrefix, broadcaster, flushInterval)
-	if !noStats {
-		wg.Add(1)
-		go metrics.FlushPeriodically(flushInterval)
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+	<-term
+	log.Info("Shutting down")
+	cancel()
+	shutdown(dockerC, state)
+}
 
-		hostC := host.NewHostCollector(statsPrefix, flushInterval)
-		wg.Add(1)
-		go hostC.CollectForever()
+// runTop shows the dashboard until it is closed instead of forwarding logs
+// and metrics.
+func runTop(ctx context.Context, cancel context.CancelFunc, dockerC *docker.Collectors, state *docker.LogState) {
+	dash := top.NewDashboard(opts.statsPrefix, opts.flushInterval)
+	metrics.AddHandler(dash)
+	dockerC.AddLogHandler(dash)
 
-		wg.Add(1)
-		go dockerC.CollectForever()
-	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dockerC.WatchForever(ctx)
+	}()
 
-	if hostname == "" {
-		hostname, err = os.Hostname()
-		if err != nil {
-			log.Fatalf("ERROR: Unable to lookup hostname: %s", err)
-		}
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-term
+		cancel()
+	}()
+
+	err := dash.Run(ctx, os.Stdin, os.Stdout)
+	cancel()
+	shutdown(dockerC, state)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
 	}
+}
 
-	if !noLogs {
-		logDests, err := parseLogDestinations(logDests, logFmts)
-		if err != nil {
-			log.Fatalf("ERROR: Unable to parse log destinations: %s", err)
-		}
-
-		for _, dest := range logDests {
-			var f logger.Formatter
-			f = &logger.ShortFormatter{}
-			switch dest.format {
-			case "ext":
-				f = &logger.ExtendedFormatter{}
-			case "json":
-				f = &logger.JSONFormatter{}
-			case "syslog":
-
-				f = &logger.SyslogFormatter{
-					Hostname: hostname,
-					Severity: logger.SevInfo,
-					Facility: logger.LogLocal1,
-					Newline:  strings.HasPrefix(dest.dest, "tcp://"),
-				}
-			}
+// shutdown stops the collectors, detaches from containers, drains the log
+// handlers, saves the log state and sends the last metrics within
+// -shutdown-timeout.
+func shutdown(dockerC *docker.Collectors, state *docker.LogState) {
+	deadline := time.Now().Add(opts.shutdownTimeout)
 
-			switch dest.dest {
-			case "console":
-				f.SetColored(true)
-				cl, err := logger.NewConsoleLogger(os.Stdout, f)
-				if err != nil {
-					log.Fatalf("ERROR: %s", err)
-				}
-				dockerC.AddLogHandler(cl)
-			default:
-				f.SetColored(false)
-				sl, err := logger.NewSocketLogger(dest.dest, nil, f)
-				if err != nil {
-					log.Fatalf("ERROR: %s", err)
-				}
-				dockerC.AddLogHandler(sl)
-			}
-		}
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(deadline.Sub(time.Now())):
+		log.Warn("Timed out stopping collectors")
 	}
-	go broadcaster.WatchForever()
 
-	wg.Wait()
+	if err := dockerC.Close(deadline.Sub(time.Now())); err != nil {
+		log.Errorf("ERROR: %s", err)
+	}
+	if err := state.Save(); err != nil {
+		log.Errorf("ERROR: Unable to save log state: %s", err)
+	}
+
+	if !opts.noStats {
+		metrics.Flush(deadline.Sub(time.Now()))
+	}
+	if err := metrics.Close(deadline.Sub(time.Now())); err != nil {
+		log.Errorf("ERROR: %s", err)
+	}
 }
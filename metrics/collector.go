@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
@@ -11,49 +13,173 @@ var (
 	mu         sync.Mutex
 	metrics    = NewCollection()
 	MetricChan = make(chan Metric)
-	handlers   []chan *Collection
+	handlers   = map[Handler]chan *Collection{}
+	running    sync.WaitGroup
+
+	// closeDeadline is when Close stops waiting for the handlers.
+	closeDeadline time.Time
+
+	// Self records metrics about hud itself.
+	Self = &Collector{}
 )
 
 type Handler interface {
 	SendForever(metrics chan *Collection)
 }
 
-func GetOrRegisterCounter(name string) *Counter {
-	return metrics.GetOrRegisterCounter(name)
+// Sender is implemented by handlers that can report whether a collection was
+// delivered so that it can be retried.
+type Sender interface {
+	Send(metrics *Collection) error
+}
+
+func GetOrRegisterCounter(name string, tags ...Tag) *Counter {
+	return metrics.GetOrRegisterCounter(name, tags...)
+}
+
+func GetOrRegisterGauge(name string, tags ...Tag) *Gauge {
+	return metrics.GetOrRegisterGauge(name, tags...)
 }
 
-func GetOrRegisterGauge(name string) *Gauge {
-	return metrics.GetOrRegisterGauge(name)
+func GetOrRegisterGaugeFloat64(name string, tags ...Tag) *GaugeFloat64 {
+	return metrics.GetOrRegisterGaugeFloat64(name, tags...)
 }
 
-func GetOrRegisterGaugeFloat64(name string) *GaugeFloat64 {
-	return metrics.GetOrRegisterGaugeFloat64(name)
+func GetOrRegisterHistogram(name string, buckets []float64, tags ...Tag) *Histogram {
+	return metrics.GetOrRegisterHistogram(name, buckets, tags...)
+}
+
+func GetOrRegisterSummary(name string, quantiles []float64, tags ...Tag) *Summary {
+	return metrics.GetOrRegisterSummary(name, quantiles, tags...)
 }
 
 func AddHandler(handler Handler) {
 	mu.Lock()
 	defer mu.Unlock()
 	sendChan := make(chan *Collection)
-	handlers = append(handlers, sendChan)
-	go handler.SendForever(sendChan)
+	handlers[handler] = sendChan
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		handler.SendForever(sendChan)
+		shutdownHandler(handler)
+	}()
+}
+
+// shutdownHandler releases the connections of a handler that stopped.
+func shutdownHandler(handler Handler) {
+	if s, ok := handler.(interface {
+		Shutdown()
+	}); ok {
+		s.Shutdown()
+	}
 }
 
-func FlushPeriodically(interval int) {
+// RemoveHandler stops flushing metrics to a handler.  The channel passed to
+// its SendForever is closed so it can release its resources.
+func RemoveHandler(handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	if sendChan, ok := handlers[handler]; ok {
+		close(sendChan)
+		delete(handlers, handler)
+	}
+}
+
+// AddSpooledHandler adds a handler whose collections are persisted to disk
+// before they are delivered so they survive sink outages and restarts.
+func AddSpooledHandler(name string, handler Handler, config SpoolConfig) error {
+	s, err := newSpool(name, handler, config)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[handler] = s.in
+	running.Add(1)
+	go s.spoolForever()
+	go func() {
+		defer running.Done()
+		s.sendForever()
+		shutdownHandler(handler)
+	}()
+	return nil
+}
+
+// FlushPeriodically sends a snapshot of the metrics to the handlers every
+// interval seconds until ctx is cancelled.
+func FlushPeriodically(ctx context.Context, interval int) {
 	for {
-		mu.Lock()
+		flush(0)
+		select {
+		case <-time.After(time.Duration(interval) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush sends a final snapshot of the metrics to the handlers, waiting up
+// to timeout for busy handlers.
+func Flush(timeout time.Duration) {
+	flush(timeout)
+}
+
+func flush(timeout time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
 
-		snap := metrics.Snapshot()
-		for _, handler := range handlers {
+	snap := metrics.Snapshot()
+	deadline := time.After(timeout)
+	for _, handler := range handlers {
+		if timeout == 0 {
 			select {
 			case handler <- snap:
 			default:
 				log.Warn("Full listener.  Metrics not flushed.")
 			}
+			continue
+		}
+
+		select {
+		case handler <- snap:
+		case <-deadline:
+			log.Warn("Timed out flushing metrics.")
 		}
-		//metrics = NewCollection()
-		metrics.Reset()
+	}
+	//metrics = NewCollection()
+	metrics.Reset()
+}
+
+// Close stops all handlers and waits up to timeout for them to finish
+// sending and close their connections.  Spooled handlers try to deliver
+// what is left in their spool until then.
+func Close(timeout time.Duration) error {
+	mu.Lock()
+	closeDeadline = time.Now().Add(timeout)
+	for handler, sendChan := range handlers {
+		close(sendChan)
+		delete(handlers, handler)
+	}
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		closeDeadline = time.Time{}
 		mu.Unlock()
-		time.Sleep(time.Duration(interval) * time.Second)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out waiting for metrics handlers")
 	}
 }
 
@@ -61,21 +187,46 @@ type Collector struct {
 	Prefix string
 }
 
-func (c *Collector) RecordGauge(name string, value int64) {
-	metric := GetOrRegisterGauge(c.metricName(name))
+func (c *Collector) RecordGauge(name string, value int64, tags ...Tag) {
+	metric := GetOrRegisterGauge(c.metricName(name), tags...)
 	metric.Set(value)
 }
 
-func (c *Collector) RecordGaugeFloat64(name string, value float64) {
-	metric := GetOrRegisterGaugeFloat64(c.metricName(name))
+func (c *Collector) RecordGaugeFloat64(name string, value float64, tags ...Tag) {
+	metric := GetOrRegisterGaugeFloat64(c.metricName(name), tags...)
 	metric.Set(value)
 }
 
-func (c *Collector) RecordCount(name string, value int64) {
-	metric := GetOrRegisterCounter(c.metricName(name))
+func (c *Collector) RecordCount(name string, value int64, tags ...Tag) {
+	metric := GetOrRegisterCounter(c.metricName(name), tags...)
 	metric.Inc(value)
 }
 
+// RecordHistogram observes value in a histogram with the given buckets.  If
+// buckets is nil, DefaultBuckets are used.
+func (c *Collector) RecordHistogram(name string, buckets []float64, value float64, tags ...Tag) {
+	metric := GetOrRegisterHistogram(c.metricName(name), buckets, tags...)
+	metric.Observe(value)
+}
+
+// RecordSummary observes value in a summary of DefaultQuantiles.
+func (c *Collector) RecordSummary(name string, value float64, tags ...Tag) {
+	metric := GetOrRegisterSummary(c.metricName(name), nil, tags...)
+	metric.Observe(value)
+}
+
+// recordSend records how long a sink took to send a collection and whether
+// it failed.
+func recordSend(sink string, start time.Time, err error) {
+	tag := PathTag("sink", sink)
+	Self.RecordHistogram("hud.sink.duration", nil, time.Since(start).Seconds(), tag)
+	if err != nil {
+		Self.RecordCount("hud.sink.errors", 1, tag)
+	} else {
+		Self.RecordCount("hud.sink.sent", 1, tag)
+	}
+}
+
 func (c *Collector) metricName(name string) string {
 	if c.Prefix != "" {
 		return c.Prefix + "." + name
@@ -3,21 +3,25 @@ package metrics
 import (
 	"fmt"
 	//"log"
+	log "github.com/Sirupsen/logrus"
 	"net"
 	"strings"
+	"sync"
 	"time"
-	log "github.com/Sirupsen/logrus"
 )
 
+var graphiteTagReplacer = strings.NewReplacer(";", "_", "~", "_", "=", "_", " ", "_")
+
 // Graphite represents a Graphite server. You Register expvars
 // in this struct, which will be published to the server on a
 // regular interval.
 type Graphite struct {
-	endpoint   string
-	interval   time.Duration
-	timeout    time.Duration
+	endpoint string
+	interval time.Duration
+	timeout  time.Duration
+	sync.Mutex
 	connection net.Conn
-	shutdown   chan chan bool
+	flat       bool
 }
 
 // NewGraphite returns a Graphite structure with an open and working
@@ -25,12 +29,14 @@ type Graphite struct {
 // Endpoint should be of the format "host:port", eg. "stats:2003".
 // Interval is the (best-effort) minimum duration between (sequential)
 // publishments of Registered expvars. Timeout is per-publish-action.
-func NewGraphite(endpoint string, timeout time.Duration) (*Graphite, error) {
+// If flat is true, metric tags are encoded in the metric path instead of
+// using the Graphite tagged series syntax.
+func NewGraphite(endpoint string, timeout time.Duration, flat bool) (*Graphite, error) {
 	g := &Graphite{
 		endpoint:   endpoint,
 		timeout:    timeout,
 		connection: nil,
-		shutdown:   make(chan chan bool),
+		flat:       flat,
 	}
 	if err := g.reconnect(); err != nil {
 		return nil, err
@@ -38,48 +44,108 @@ func NewGraphite(endpoint string, timeout time.Duration) (*Graphite, error) {
 	return g, nil
 }
 
-// Shutdown signals the Graphite structure to stop publishing
-// Registered expvars.
+// Shutdown closes the connection to the Graphite server.  It is called once
+// the handler is removed or hud shuts down.
 func (g *Graphite) Shutdown() {
-	q := make(chan bool)
-	g.shutdown <- q
-	<-q
+	g.Lock()
+	defer g.Unlock()
+	if g.connection != nil {
+		g.connection.Close()
+		g.connection = nil
+	}
 }
 
 func (g *Graphite) SendForever(metrics chan *Collection) {
-	for {
-		select {
-		case collection := <-metrics:
-			m := collection.Metrics()
-			for name, metric := range m {
-				val := metric.Value()
-				strVal := "0"
-				switch val := val.(type) {
-				case int64:
-					strVal = fmt.Sprintf("%d", val)
-				case float64:
-					strVal = fmt.Sprintf("%0.2f", val)
-				default:
-					log.Errorf("Unhandled graphite type: %s", val)
-				}
-
-				if err := g.sendOne(strings.Replace(name, "/", "_", -1), strVal); err != nil {
-					log.Errorf("ERROR: %s: %s", name, err)
-				}
+	for collection := range metrics {
+		if err := g.Send(collection); err != nil {
+			log.Errorf("ERROR: %s", err)
+		}
+	}
+}
+
+// Send publishes every metric in the collection.  Sending continues after a
+// failure, reconnecting as needed, and the last error is returned.
+func (g *Graphite) Send(collection *Collection) (err error) {
+	defer func(start time.Time) { recordSend("graphite", start, err) }(time.Now())
+
+	g.Lock()
+	defer g.Unlock()
+
+	var lastErr error
+	failed := 0
+	m := collection.Metrics()
+	for _, metric := range m {
+		ts := collection.Timestamp(metric)
+		for _, v := range g.values(metric) {
+			name := g.metricName(metric, v[0])
+			if err := g.sendOne(name, v[1], ts); err != nil {
+				lastErr = fmt.Errorf("%s: %s", name, err)
+				failed++
+			}
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("%d of %d metrics not sent: %s", failed, len(m), lastErr)
+	}
+	return nil
+}
+
+// values returns the path suffixes and values to publish for a metric.
+// Histograms and summaries are published as .count, .sum and .pNN paths.
+func (g *Graphite) values(metric Metric) [][2]string {
+	switch val := metric.Value().(type) {
+	case int64:
+		return [][2]string{{"", fmt.Sprintf("%d", val)}}
+	case float64:
+		return [][2]string{{"", fmt.Sprintf("%0.2f", val)}}
+	case Distribution:
+		values := [][2]string{}
+		for _, f := range val.fields() {
+			switch v := f.value.(type) {
+			case int64:
+				values = append(values, [2]string{f.name, fmt.Sprintf("%d", v)})
+			case float64:
+				values = append(values, [2]string{f.name, fmt.Sprintf("%0.4f", v)})
 			}
-		case q := <-g.shutdown:
-			g.connection.Close()
-			g.connection = nil
-			q <- true
-			return
 		}
+		return values
+	default:
+		log.Errorf("Unhandled graphite type: %v", val)
+		return nil
+	}
+}
+
+// metricName returns the Graphite path of a metric with an optional suffix,
+// either flattened or in the tagged series format: name;tag1=value1
+func (g *Graphite) metricName(metric Metric, suffix string) string {
+	name := metric.Name()
+	if g.flat {
+		name = metric.Tags().Flatten(name)
+	}
+	if suffix != "" {
+		name += "." + suffix
+	}
+
+	name = strings.Replace(name, "/", "_", -1)
+	if g.flat {
+		return name
+	}
+
+	for _, tag := range metric.Tags() {
+		if tag.Value == "" {
+			continue
+		}
+		name += ";" + graphiteTagReplacer.Replace(tag.Key) + "=" + graphiteTagReplacer.Replace(tag.Value)
 	}
+	return name
 }
 
-// sendOne publishes the given name-value pair to the Graphite server.
-// If the connection is broken, one reconnect attempt is made.
-func (g *Graphite) sendOne(name, value string) error {
+// sendOne publishes the given name-value pair to the Graphite server at the
+// given time. If the connection is broken, one reconnect attempt is made.
+func (g *Graphite) sendOne(name, value string, ts time.Time) error {
 	if g.connection == nil {
+		Self.RecordCount("hud.reconnects", 1, PathTag("component", "graphite"))
 		if err := g.reconnect(); err != nil {
 			return fmt.Errorf("failed; reconnect attempt: %s", err)
 		}
@@ -89,7 +155,7 @@ func (g *Graphite) sendOne(name, value string) error {
 		g.connection = nil
 		return fmt.Errorf("SetWriteDeadline: %s", err)
 	}
-	p := fmt.Sprintf("%s %s %d", name, value, time.Now().Unix())
+	p := fmt.Sprintf("%s %s %d", name, value, ts.Unix())
 	b := []byte(p + "\n")
 	log.Debug(p)
 
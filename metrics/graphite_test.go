@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestGraphiteMetricName(t *testing.T) {
+	m := NewGauge("system.disk.bytes.read.mount", PathTag("mount", "/var/lib"), NewTag("fs", "ext4"))
+
+	g := &Graphite{}
+	if name := g.metricName(m, ""); name != "system.disk.bytes.read.mount;mount=/var/lib;fs=ext4" {
+		t.Fatalf("expected tagged name, got %s", name)
+	}
+
+	g.flat = true
+	if name := g.metricName(m, ""); name != "system.disk.bytes.read.mount._var_lib" {
+		t.Fatalf("expected flat name, got %s", name)
+	}
+}
+
+func TestGraphiteHistogramValues(t *testing.T) {
+	h := NewHistogram("docker.logs.size", []float64{10, 100}, PathTag("container", "web"))
+	h.Observe(5)
+	h.Observe(50)
+
+	g := &Graphite{flat: true}
+	values := g.values(h)
+	expected := [][2]string{
+		{"count", "2"},
+		{"sum", "55.0000"},
+		{"p50", "10.0000"},
+		{"p90", "82.0000"},
+		{"p99", "98.2000"},
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], values[i])
+		}
+	}
+
+	if name := g.metricName(h, "p99"); name != "docker.logs.size.web.p99" {
+		t.Fatalf("expected docker.logs.size.web.p99, got %s", name)
+	}
+}
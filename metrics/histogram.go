@@ -0,0 +1,488 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultBuckets are histogram buckets suited to durations in seconds.
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// SizeBuckets are histogram buckets suited to sizes in bytes, from 64B
+	// to 1MB.
+	SizeBuckets = ExponentialBuckets(64, 4, 8)
+
+	// DefaultQuantiles are the quantiles reported for histograms and
+	// summaries.
+	DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+)
+
+// ExponentialBuckets returns count buckets where the first upper bound is
+// start and each following bound is factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Bucket is the cumulative number of observations less than or equal to
+// UpperBound.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      int64   `json:"count"`
+}
+
+// Quantile is the value below which a fraction of the observations fall.
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Distribution is the value of a Histogram or Summary.  Buckets are only
+// set for histograms.
+type Distribution struct {
+	Count     int64      `json:"count"`
+	Sum       float64    `json:"sum"`
+	Buckets   []Bucket   `json:"buckets,omitempty"`
+	Quantiles []Quantile `json:"quantiles"`
+}
+
+// Histogram counts observations in configurable buckets.  Quantiles are
+// estimated from the buckets.
+type Histogram struct {
+	sync.Mutex
+	name   string
+	tags   Tags
+	ts     time.Time
+	bounds []float64
+	counts []int64
+	count  int64
+	sum    float64
+	max    float64
+}
+
+func NewHistogram(name string, buckets []float64, tags ...Tag) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+
+	return &Histogram{
+		name:   name,
+		tags:   tags,
+		bounds: bounds,
+		counts: make([]int64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	if h.count == 0 || value > h.max {
+		h.max = value
+	}
+	h.count++
+	h.sum += value
+	h.ts = time.Now()
+}
+
+func (h *Histogram) Snapshot() Metric {
+	h.Lock()
+	defer h.Unlock()
+	snap := NewHistogram(h.name, h.bounds, h.tags...)
+	copy(snap.counts, h.counts)
+	snap.count = h.count
+	snap.sum = h.sum
+	snap.max = h.max
+	snap.ts = h.ts
+	return snap
+}
+
+// merge adds the observations of other to h.  Observations in buckets that
+// h does not have are only counted in the total.
+func (h *Histogram) merge(other *Histogram) {
+	other.Lock()
+	bounds := other.bounds
+	counts := append([]int64{}, other.counts...)
+	count, sum, max, ts := other.count, other.sum, other.max, other.ts
+	other.Unlock()
+
+	h.Lock()
+	defer h.Unlock()
+	for i, bound := range h.bounds {
+		for j, b := range bounds {
+			if b == bound {
+				h.counts[i] += counts[j]
+			}
+		}
+	}
+	if h.count == 0 || max > h.max {
+		h.max = max
+	}
+	h.count += count
+	h.sum += sum
+	if ts.After(h.ts) {
+		h.ts = ts
+	}
+}
+
+func (h *Histogram) Reset() {
+	h.Lock()
+	defer h.Unlock()
+	h.counts = make([]int64, len(h.bounds))
+	h.count = 0
+	h.sum = 0
+	h.max = 0
+	h.ts = time.Time{}
+}
+
+func (h *Histogram) Value() interface{} {
+	h.Lock()
+	defer h.Unlock()
+
+	d := Distribution{
+		Count: h.count,
+		Sum:   h.sum,
+	}
+	for i, bound := range h.bounds {
+		d.Buckets = append(d.Buckets, Bucket{UpperBound: bound, Count: h.counts[i]})
+	}
+	for _, q := range DefaultQuantiles {
+		d.Quantiles = append(d.Quantiles, Quantile{Quantile: q, Value: h.quantile(q)})
+	}
+	return d
+}
+
+// quantile estimates a quantile by interpolating linearly within the bucket
+// it falls in.  Values above the largest bucket are estimated as the largest
+// observed value.
+func (h *Histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.count)
+	prevBound, prevCount := 0.0, int64(0)
+	for i, bound := range h.bounds {
+		count := h.counts[i]
+		if float64(count) >= rank {
+			if count == prevCount {
+				return bound
+			}
+			return prevBound + (bound-prevBound)*(rank-float64(prevCount))/float64(count-prevCount)
+		}
+		prevBound, prevCount = bound, count
+	}
+	return h.max
+}
+
+func (h *Histogram) Name() string {
+	return h.name
+}
+
+func (h *Histogram) Tags() Tags {
+	return h.tags
+}
+
+func (h *Histogram) Time() time.Time {
+	h.Lock()
+	defer h.Unlock()
+	return h.ts
+}
+
+// Summary tracks streaming quantiles of observations using the targeted
+// quantile algorithm of Cormode, Korn, Muthukrishnan and Srivastava.  The
+// quantiles 0 and 1 and any outside of them are not estimated: they report
+// the minimum and maximum observed.
+type Summary struct {
+	sync.Mutex
+	name      string
+	tags      Tags
+	ts        time.Time
+	quantiles []float64
+	stream    *quantileStream
+	count     int64
+	sum       float64
+	min       float64
+	max       float64
+
+	// frozen is the value of a snapshotted summary.
+	frozen *Distribution
+}
+
+func NewSummary(name string, quantiles []float64, tags ...Tag) *Summary {
+	if len(quantiles) == 0 {
+		quantiles = DefaultQuantiles
+	}
+
+	return &Summary{
+		name:      name,
+		tags:      tags,
+		quantiles: quantiles,
+		stream:    newQuantileStream(quantiles),
+	}
+}
+
+func (s *Summary) Observe(value float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.stream.insert(value)
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+	s.ts = time.Now()
+}
+
+func (s *Summary) Snapshot() Metric {
+	d := s.Value().(Distribution)
+
+	s.Lock()
+	defer s.Unlock()
+	snap := NewSummary(s.name, s.quantiles, s.tags...)
+	snap.count = s.count
+	snap.sum = s.sum
+	snap.ts = s.ts
+	snap.frozen = &d
+	return snap
+}
+
+func (s *Summary) Reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.stream = newQuantileStream(s.quantiles)
+	s.count = 0
+	s.sum = 0
+	s.min = 0
+	s.max = 0
+	s.ts = time.Time{}
+	s.frozen = nil
+}
+
+func (s *Summary) Value() interface{} {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.frozen != nil {
+		return *s.frozen
+	}
+
+	d := Distribution{
+		Count: s.count,
+		Sum:   s.sum,
+	}
+	for _, q := range s.quantiles {
+		v := s.min
+		switch {
+		case q >= 1:
+			v = s.max
+		case q > 0:
+			v = s.stream.query(q)
+		}
+		d.Quantiles = append(d.Quantiles, Quantile{Quantile: q, Value: v})
+	}
+	return d
+}
+
+func (s *Summary) Name() string {
+	return s.name
+}
+
+func (s *Summary) Tags() Tags {
+	return s.tags
+}
+
+func (s *Summary) Time() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.ts
+}
+
+type quantileTarget struct {
+	quantile float64
+	epsilon  float64
+}
+
+type quantileSample struct {
+	value float64
+	width float64
+	delta float64
+}
+
+// quantileStream is a CKMS biased quantile stream.  Observations are
+// buffered and merged into a compressed list of samples whose error bounds
+// are tightest around the targeted quantiles.
+type quantileStream struct {
+	targets []quantileTarget
+	samples []quantileSample
+	buffer  []float64
+	n       float64
+}
+
+const quantileBufferSize = 500
+
+func newQuantileStream(quantiles []float64) *quantileStream {
+	targets := []quantileTarget{}
+	for _, q := range quantiles {
+		// the error allowed at 0 and 1 would be zero, Summary reports
+		// the minimum and maximum instead
+		if q <= 0 || q >= 1 {
+			continue
+		}
+		// allow more error for lower quantiles: p50 +/- 5%, p99 +/- 0.1%
+		targets = append(targets, quantileTarget{quantile: q, epsilon: (1 - q) / 10})
+	}
+	return &quantileStream{
+		targets: targets,
+		buffer:  make([]float64, 0, quantileBufferSize),
+	}
+}
+
+func (s *quantileStream) insert(v float64) {
+	s.buffer = append(s.buffer, v)
+	if len(s.buffer) == cap(s.buffer) {
+		s.flush()
+	}
+}
+
+func (s *quantileStream) query(q float64) float64 {
+	s.flush()
+	if len(s.samples) == 0 {
+		return 0
+	}
+
+	t := math.Ceil(q * s.n)
+	t += math.Ceil(s.invariant(t) / 2)
+	prev := s.samples[0]
+	r := 0.0
+	for _, c := range s.samples[1:] {
+		r += prev.width
+		if r+c.width+c.delta > t {
+			return prev.value
+		}
+		prev = c
+	}
+	return prev.value
+}
+
+// invariant returns the maximum allowed error at rank r.
+func (s *quantileStream) invariant(r float64) float64 {
+	m := math.MaxFloat64
+	for _, t := range s.targets {
+		var f float64
+		if t.quantile*s.n <= r {
+			f = (2 * t.epsilon * r) / t.quantile
+		} else {
+			f = (2 * t.epsilon * (s.n - r)) / (1 - t.quantile)
+		}
+		if f < m {
+			m = f
+		}
+	}
+	return m
+}
+
+func (s *quantileStream) flush() {
+	if len(s.buffer) == 0 {
+		return
+	}
+
+	sort.Float64s(s.buffer)
+	r := 0.0
+	i := 0
+	for _, v := range s.buffer {
+		inserted := false
+		for ; i < len(s.samples); i++ {
+			c := s.samples[i]
+			if c.value > v {
+				s.samples = append(s.samples, quantileSample{})
+				copy(s.samples[i+1:], s.samples[i:])
+				s.samples[i] = quantileSample{
+					value: v,
+					width: 1,
+					delta: math.Max(0, math.Floor(s.invariant(r))-1),
+				}
+				i++
+				inserted = true
+				break
+			}
+			r += c.width
+		}
+		if !inserted {
+			s.samples = append(s.samples, quantileSample{value: v, width: 1})
+			i++
+		}
+		s.n++
+		r++
+	}
+	s.buffer = s.buffer[:0]
+	s.compress()
+}
+
+// compress merges adjacent samples while the error invariant allows it.
+func (s *quantileStream) compress() {
+	if len(s.samples) < 2 {
+		return
+	}
+
+	x := s.samples[len(s.samples)-1]
+	xi := len(s.samples) - 1
+	r := s.n - 1 - x.width
+	for i := len(s.samples) - 2; i >= 0; i-- {
+		c := s.samples[i]
+		if c.width+x.width+x.delta <= s.invariant(r) {
+			x.width += c.width
+			s.samples[xi] = x
+			copy(s.samples[i:], s.samples[i+1:])
+			s.samples = s.samples[:len(s.samples)-1]
+			xi--
+		} else {
+			x = c
+			xi = i
+		}
+		r -= c.width
+	}
+}
+
+// distributionField is a single named value of a Distribution as reported
+// by sinks without native distribution support.
+type distributionField struct {
+	name  string
+	value interface{}
+}
+
+// fields returns the count, sum and quantiles of a distribution.
+func (d Distribution) fields() []distributionField {
+	fields := []distributionField{
+		{"count", d.Count},
+		{"sum", d.Sum},
+	}
+	for _, q := range d.Quantiles {
+		fields = append(fields, distributionField{quantileName(q.Quantile), q.Value})
+	}
+	return fields
+}
+
+// quantileName returns the field name of a quantile, e.g. p99 or p99_9.
+func quantileName(q float64) string {
+	return "p" + strings.Replace(strconv.FormatFloat(q*100, 'f', -1, 64), ".", "_", -1)
+}
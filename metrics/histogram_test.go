@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram("docker.logs.size", []float64{100, 10, 1000})
+	for _, v := range []float64{5, 50, 500, 5000} {
+		h.Observe(v)
+	}
+
+	d := h.Value().(Distribution)
+	if d.Count != 4 || d.Sum != 5555 {
+		t.Fatalf("unexpected count/sum: %d %f", d.Count, d.Sum)
+	}
+
+	expected := []Bucket{{10, 1}, {100, 2}, {1000, 3}}
+	for i, b := range expected {
+		if d.Buckets[i] != b {
+			t.Fatalf("expected bucket %v, got %v", b, d.Buckets[i])
+		}
+	}
+
+	// p99 falls above the largest bucket and is estimated as the max
+	if p99 := d.Quantiles[2].Value; p99 != 5000 {
+		t.Fatalf("expected p99 of 5000, got %f", p99)
+	}
+
+	snap := h.Snapshot()
+	h.Reset()
+	if snap.Value().(Distribution).Count != 4 {
+		t.Fatalf("expected snapshot to keep observations")
+	}
+	if h.Value().(Distribution).Count != 0 {
+		t.Fatalf("expected reset histogram to be empty")
+	}
+}
+
+func TestSummaryQuantiles(t *testing.T) {
+	s := NewSummary("hud.sink.duration", nil)
+
+	r := rand.New(rand.NewSource(1))
+	for _, v := range r.Perm(10000) {
+		s.Observe(float64(v + 1))
+	}
+
+	d := s.Value().(Distribution)
+	if d.Count != 10000 {
+		t.Fatalf("expected 10000 observations, got %d", d.Count)
+	}
+
+	for _, q := range d.Quantiles {
+		expected := q.Quantile * 10000
+		allowed := (1 - q.Quantile) / 10 * 10000 * 2
+		if math.Abs(q.Value-expected) > allowed {
+			t.Fatalf("%s: expected %f +/- %f, got %f", quantileName(q.Quantile), expected, allowed, q.Value)
+		}
+	}
+
+	snap := s.Snapshot()
+	s.Observe(100000)
+	if snap.Value().(Distribution).Count != 10000 {
+		t.Fatalf("expected snapshot to be frozen")
+	}
+}
+
+func TestSummaryExtremeQuantiles(t *testing.T) {
+	s := NewSummary("hud.sink.duration", []float64{0, 0.5, 1})
+	r := rand.New(rand.NewSource(1))
+	for _, v := range r.Perm(1000) {
+		s.Observe(float64(v + 1))
+	}
+
+	d := s.Value().(Distribution)
+	if d.Quantiles[0].Value != 1 || d.Quantiles[2].Value != 1000 {
+		t.Fatalf("expected the minimum and maximum for quantiles 0 and 1, got %+v", d.Quantiles)
+	}
+	if p50 := d.Quantiles[1].Value; math.IsNaN(p50) || math.Abs(p50-500) > 100 {
+		t.Fatalf("expected p50 near 500, got %f", p50)
+	}
+}
@@ -1,97 +1,324 @@
 package metrics
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
 	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	DefaultInfluxDBTimeout    = 10 * time.Second
+	DefaultInfluxDBMaxRetries = 3
+	maxInfluxDBBackoff        = 30 * time.Second
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
 
-	influxClient "github.com/influxdb/influxdb/client"
+	// influxPrecisions maps precisions to their duration and the name of the
+	// precision used by the v1 API.
+	influxPrecisions = map[string]struct {
+		unit time.Duration
+		v1   string
+	}{
+		"ns": {time.Nanosecond, "n"},
+		"us": {time.Microsecond, "u"},
+		"ms": {time.Millisecond, "ms"},
+		"s":  {time.Second, "s"},
+	}
 )
 
+// InfluxDBConfig configures an InfluxDB handler.  Database, RetentionPolicy,
+// Username and Password are used by the v1 /write API.  If Token, Org or
+// Bucket are set, the v2 /api/v2/write API is used instead.
+type InfluxDBConfig struct {
+	URL             string
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+	Org             string
+	Bucket          string
+	Token           string
+	Precision       string
+	Gzip            bool
+	Timeout         time.Duration
+	MaxRetries      int
+	Flat            bool
+}
+
+// InfluxDBError is returned when InfluxDB rejects some or all of the points
+// in a batch.
+type InfluxDBError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *InfluxDBError) Error() string {
+	if e.Partial() {
+		return fmt.Sprintf("influxdb partial write (%d): %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("influxdb write failed (%d): %s", e.StatusCode, e.Message)
+}
+
+// Partial returns true if InfluxDB accepted some of the points in the batch.
+func (e *InfluxDBError) Partial() bool {
+	return strings.Contains(e.Message, "partial write")
+}
+
+func (e *InfluxDBError) temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// InfluxDB writes metrics to InfluxDB using the line protocol.
 type InfluxDB struct {
-	addr string
-	user string
-	pass string
-	db   string
+	config   InfluxDBConfig
+	writeURL string
+	client   *http.Client
+	backoff  time.Duration
 }
 
-func NewInfluxDB(user, pass, addr, db string) (*InfluxDB, error) {
+func NewInfluxDB(config InfluxDBConfig) (*InfluxDB, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("influxdb url is required")
+	}
+
+	if !strings.Contains(config.URL, "://") {
+		config.URL = "http://" + config.URL
+	}
+
+	if config.Precision == "" {
+		config.Precision = "s"
+	}
+
+	precision, ok := influxPrecisions[config.Precision]
+	if !ok {
+		return nil, fmt.Errorf("unsupported influxdb precision: %s", config.Precision)
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultInfluxDBTimeout
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("bad influxdb url: %s", err)
+	}
+
+	params := url.Values{}
+	if config.Token != "" || config.Org != "" || config.Bucket != "" {
+		if config.Bucket == "" {
+			return nil, fmt.Errorf("influxdb bucket is required")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/api/v2/write"
+		params.Set("org", config.Org)
+		params.Set("bucket", config.Bucket)
+		params.Set("precision", config.Precision)
+	} else {
+		if config.Database == "" {
+			return nil, fmt.Errorf("influxdb database is required")
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/write"
+		params.Set("db", config.Database)
+		if config.RetentionPolicy != "" {
+			params.Set("rp", config.RetentionPolicy)
+		}
+		if config.Username != "" {
+			params.Set("u", config.Username)
+			params.Set("p", config.Password)
+		}
+		params.Set("precision", precision.v1)
+	}
+	u.RawQuery = params.Encode()
+
 	return &InfluxDB{
-		user: user,
-		pass: pass,
-		addr: addr,
-		db:   db,
+		config:   config,
+		writeURL: u.String(),
+		client:   &http.Client{Timeout: config.Timeout},
+		backoff:  time.Second,
 	}, nil
 }
+
 func (w *InfluxDB) SendForever(metrics chan *Collection) {
+	for col := range metrics {
+		if err := w.Send(col); err != nil {
+			log.Errorf("ERROR: %s", err)
+		}
+	}
+}
+
+// Send writes a collection to InfluxDB, retrying temporary failures with an
+// exponential backoff.
+func (w *InfluxDB) Send(col *Collection) (err error) {
+	defer func(start time.Time) { recordSend("influxdb", start, err) }(time.Now())
 
-	config := &influxClient.ClientConfig{
-		Host:     w.addr,
-		Database: w.db,
+	body, err := w.encode(col)
+	if err != nil {
+		return err
 	}
 
-	if w.user != "" {
-		config.Username = w.user
-		config.Password = w.pass
+	if len(body) == 0 {
+		return nil
 	}
 
-	client, err := influxClient.NewClient(config)
+	backoff := w.backoff
+	for attempt := 0; ; attempt++ {
+		err = w.write(body)
+		if err == nil {
+			return nil
+		}
 
-	if err != nil {
-		log.Fatalf("ERROR: %s", err)
+		if e, ok := err.(*InfluxDBError); ok && !e.temporary() {
+			return err
+		}
+
+		if attempt >= w.config.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %s", attempt+1, err)
+		}
+
+		log.Warnf("InfluxDB write failed, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxInfluxDBBackoff {
+			backoff = maxInfluxDBBackoff
+		}
 	}
+}
 
-	for {
+// encode returns the line protocol representation of a collection.  Points
+// are written at the time they were recorded rather than when they are sent.
+func (w *InfluxDB) encode(col *Collection) ([]byte, error) {
+	unit := int64(influxPrecisions[w.config.Precision].unit)
 
-	RETRY:
-		err = client.Ping()
-		if err != nil {
-			log.Errorf("ERROR: %s", err)
-			time.Sleep(10 * time.Second)
-			goto RETRY
+	var buf bytes.Buffer
+	for _, metric := range col.Metrics() {
+		var fields string
+		switch v := metric.Value().(type) {
+		case int64, float64:
+			fields = "value=" + influxValue(v)
+		case Distribution:
+			pairs := []string{}
+			for _, f := range v.fields() {
+				pairs = append(pairs, f.name+"="+influxValue(f.value))
+			}
+			fields = strings.Join(pairs, ",")
+		default:
+			log.Errorf("Unhandled influxdb type: %v", v)
+			continue
+		}
 
+		name := metric.Name()
+		tags := metric.Tags()
+		if w.config.Flat {
+			name, tags = tags.Flatten(name), nil
 		}
 
-		err = client.AuthenticateDatabaseUser(config.Database, config.Username, config.Password)
-		if err != nil {
-			log.Errorf("ERROR: Unable to connect to influxdb: %s", err)
-			time.Sleep(10 * time.Second)
-			goto RETRY
+		buf.WriteString(influxMeasurementEscaper.Replace(name))
+		buf.WriteString(influxTags(tags))
+		fmt.Fprintf(&buf, " %s %d\n", fields, col.Timestamp(metric).UnixNano()/unit)
+	}
+
+	if !w.config.Gzip || buf.Len() == 0 {
+		return buf.Bytes(), nil
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return gz.Bytes(), nil
+}
+
+// influxValue returns the line protocol representation of a field value.
+func influxValue(v interface{}) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// influxTags returns the line protocol tag set for tags, sorted by key as
+// recommended by InfluxDB.  Tags with empty values are not allowed and are
+// skipped.
+func influxTags(tags Tags) string {
+	pairs := []string{}
+	for _, tag := range tags {
+		if tag.Value == "" {
+			continue
 		}
+		pairs = append(pairs, influxTagEscaper.Replace(tag.Key)+"="+influxTagEscaper.Replace(tag.Value))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	sort.Strings(pairs)
+	return "," + strings.Join(pairs, ",")
+}
 
-		series := []*influxClient.Series{}
+func (w *InfluxDB) write(body []byte) error {
+	req, err := http.NewRequest("POST", w.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
 
-		for {
-			col := <-metrics
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	}
 
-			now := time.Now().Unix()
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-			columns := []string{"time", "value"}
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
 
-			for k, metric := range col.Metrics() {
-				v := metric.Value()
-				serie := &influxClient.Series{
-					Name:    k,
-					Columns: columns,
-				}
+	data, _ := ioutil.ReadAll(resp.Body)
+	return &InfluxDBError{
+		StatusCode: resp.StatusCode,
+		Message:    influxErrorMessage(data),
+	}
+}
 
-				var dp []interface{}
-				switch v := v.(type) {
-				case int64:
-					dp = []interface{}{now, v}
-				case float64:
-					dp = []interface{}{now, v}
+// influxErrorMessage extracts the error message from a v1 ({"error": ...})
+// or v2 ({"code": ..., "message": ...}) error response.
+func influxErrorMessage(data []byte) string {
+	var resp struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
 
-				}
-				serie.Points = [][]interface{}{dp}
-				series = append(series, serie)
-			}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return strings.TrimSpace(string(data))
+	}
 
-			err := client.WriteSeriesWithTimePrecision(series, influxClient.Second)
-			if err != nil {
-				log.Errorf("ERROR: %s", err)
-				time.Sleep(10 * time.Second)
-				goto RETRY
-			}
-		}
+	if resp.Error != "" {
+		return resp.Error
 	}
+	return resp.Message
 }
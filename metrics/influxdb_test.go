@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInfluxDBEncode(t *testing.T) {
+	w, err := NewInfluxDB(InfluxDBConfig{URL: "localhost:8086", Database: "hud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := NewCollection()
+	col.GetOrRegisterGauge("docker.mem.rss", PathTag("container", "web 1"), NewTag("image", "nginx")).Set(10)
+	col.GetOrRegisterGaugeFloat64("system.load.load1").Set(0.5)
+	col.GetOrRegisterCounter("docker.events", PathTag("status", "die"))
+	col.GetOrRegisterSummary("hud.sink.duration", []float64{0.5}).Observe(2)
+
+	col = col.Snapshot()
+	col.ts = time.Unix(100, 0)
+	col.GetOrRegisterGauge("docker.mem.rss", PathTag("container", "web 1"), NewTag("image", "nginx")).ts = time.Unix(90, 0)
+
+	body, err := w.encode(col)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"docker.mem.rss,container=web\\ 1,image=nginx value=10i 90\n",
+		"docker.events,status=die value=0i 100\n",
+		`hud.sink.duration count=1i,sum=2,p50=2 `,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("expected %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestInfluxDBWriteV1(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		query = r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w, err := NewInfluxDB(InfluxDBConfig{
+		URL:             ts.URL,
+		Database:        "hud",
+		RetentionPolicy: "week",
+		Username:        "user",
+		Password:        "pass",
+		Precision:       "ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := NewCollection()
+	col.GetOrRegisterGauge("docker.containers").Set(1)
+	if err := w.Send(col); err != nil {
+		t.Fatal(err)
+	}
+
+	if query != "db=hud&p=pass&precision=ms&rp=week&u=user" {
+		t.Fatalf("unexpected query: %s", query)
+	}
+}
+
+func TestInfluxDBWriteV2Gzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("bucket") != "metrics" || r.URL.Query().Get("org") != "ops" {
+			t.Fatalf("unexpected query: %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") != "Token secret" {
+			t.Fatalf("unexpected authorization: %s", r.Header.Get("Authorization"))
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(zr)
+		if !strings.HasPrefix(string(data), "docker.containers value=1i ") {
+			t.Fatalf("unexpected body: %s", data)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w, err := NewInfluxDB(InfluxDBConfig{
+		URL:    ts.URL,
+		Org:    "ops",
+		Bucket: "metrics",
+		Token:  "secret",
+		Gzip:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := NewCollection()
+	col.GetOrRegisterGauge("docker.containers").Set(1)
+	if err := w.Send(col); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInfluxDBRetry(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	w, err := NewInfluxDB(InfluxDBConfig{URL: ts.URL, Database: "hud", MaxRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.backoff = time.Millisecond
+
+	col := NewCollection()
+	col.GetOrRegisterGauge("docker.containers").Set(1)
+	if err := w.Send(col); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestInfluxDBPartialWrite(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"partial write: field type conflict dropped=1"}`))
+	}))
+	defer ts.Close()
+
+	w, err := NewInfluxDB(InfluxDBConfig{URL: ts.URL, Database: "hud", MaxRetries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.backoff = time.Millisecond
+
+	col := NewCollection()
+	col.GetOrRegisterGauge("docker.containers").Set(1)
+	err = w.Send(col)
+
+	e, ok := err.(*InfluxDBError)
+	if !ok {
+		t.Fatalf("expected InfluxDBError, got %v", err)
+	}
+	if !e.Partial() {
+		t.Fatalf("expected partial write error, got %s", e)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected no retries, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+type jsonMetric struct {
+	Type  string      `json:"type"`
+	Name  string      `json:"name"`
+	Tags  Tags        `json:"tags,omitempty"`
+	Time  time.Time   `json:"time"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON encodes a collection as a list of its metrics sorted by name
+// and tags.  Distributions are encoded with their count, sum, buckets and
+// quantiles.
+func (m *Collection) MarshalJSON() ([]byte, error) {
+	keys := []string{}
+	for key := range m.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := []jsonMetric{}
+	for _, key := range keys {
+		metric := m.metrics[key]
+		list = append(list, jsonMetric{
+			Type:  metricType(metric),
+			Name:  metric.Name(),
+			Tags:  metric.Tags(),
+			Time:  m.Timestamp(metric),
+			Value: metric.Value(),
+		})
+	}
+	return json.Marshal(list)
+}
+
+// metricType returns the name of the type of a metric.
+func metricType(metric Metric) string {
+	switch metric.(type) {
+	case *Counter:
+		return "counter"
+	case *Gauge:
+		return "gauge"
+	case *GaugeFloat64:
+		return "gauge_float64"
+	case *Histogram:
+		return "histogram"
+	case *Summary:
+		return "summary"
+	}
+	return "unknown"
+}
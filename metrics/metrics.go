@@ -1,26 +1,92 @@
 package metrics
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Metric interface {
 	Name() string
+	Tags() Tags
 	Value() interface{}
+	// Time returns when the metric was last recorded, or the zero time if
+	// it has not been recorded since it was last reset.
+	Time() time.Time
 	Reset()
 	Snapshot() Metric
 }
 
+// Tag is a key/value dimension attached to a metric.  Path tags were
+// historically encoded in the metric name and are appended to it, in order,
+// when rendering legacy flat names.
+type Tag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Path  bool   `json:"path,omitempty"`
+}
+
+// NewTag returns a tag that is only rendered by sinks supporting tags.
+func NewTag(key, value string) Tag {
+	return Tag{Key: key, Value: value}
+}
+
+// PathTag returns a tag that is also part of the legacy flat metric name.
+func PathTag(key, value string) Tag {
+	return Tag{Key: key, Value: value, Path: true}
+}
+
+type Tags []Tag
+
+// Flatten returns the legacy flat name for a metric by appending the value
+// of each path tag to name.
+func (t Tags) Flatten(name string) string {
+	parts := []string{name}
+	for _, tag := range t {
+		if tag.Path {
+			parts = append(parts, strings.Replace(tag.Value, ".", "_", -1))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Map returns the tags as a map of keys to values.
+func (t Tags) Map() map[string]string {
+	m := make(map[string]string, len(t))
+	for _, tag := range t {
+		m[tag.Key] = tag.Value
+	}
+	return m
+}
+
+// metricKey returns the unique key of a metric name and set of tags within a
+// Collection.
+func metricKey(name string, tags Tags) string {
+	if len(tags) == 0 {
+		return name
+	}
+
+	pairs := []string{}
+	for _, tag := range tags {
+		pairs = append(pairs, tag.Key+"="+tag.Value)
+	}
+	sort.Strings(pairs)
+	return name + ";" + strings.Join(pairs, ";")
+}
+
 type Counter struct {
 	sync.RWMutex
 	v    int64
 	name string
-	ts   int64
+	tags Tags
+	ts   time.Time
 }
 
 type Collection struct {
 	sync.Mutex
 	metrics map[string]Metric
+	ts      time.Time
 }
 
 func NewCollection() *Collection {
@@ -33,7 +99,8 @@ func (m *Collection) Reset() {
 	m.Lock()
 	defer m.Unlock()
 	for _, v := range m.metrics {
-		if _, ok := v.(*Counter); ok {
+		switch v.(type) {
+		case *Counter, *Histogram, *Summary:
 			v.Reset()
 		}
 	}
@@ -41,6 +108,7 @@ func (m *Collection) Reset() {
 
 func (m *Collection) Snapshot() *Collection {
 	snap := NewCollection()
+	snap.ts = time.Now()
 	for k, v := range m.metrics {
 		snap.metrics[k] = v.Snapshot()
 	}
@@ -51,48 +119,101 @@ func (m *Collection) Metrics() map[string]Metric {
 	return m.metrics
 }
 
-func (m *Collection) GetOrRegisterCounter(name string) *Counter {
+// Time returns the time the collection was snapshotted.
+func (m *Collection) Time() time.Time {
+	return m.ts
+}
+
+// Timestamp returns the time a metric in the collection should be reported
+// at: when it was recorded if known, otherwise when the collection was
+// snapshotted.
+func (m *Collection) Timestamp(metric Metric) time.Time {
+	if ts := metric.Time(); !ts.IsZero() {
+		return ts
+	}
+	if !m.ts.IsZero() {
+		return m.ts
+	}
+	return time.Now()
+}
+
+func (m *Collection) GetOrRegisterCounter(name string, tags ...Tag) *Counter {
 	mu.Lock()
 	defer mu.Unlock()
-	if c, ok := m.metrics[name]; ok {
+	key := metricKey(name, tags)
+	if c, ok := m.metrics[key]; ok {
 		return c.(*Counter)
 	}
-	c := NewCounter(name)
-	m.metrics[name] = c
+	c := NewCounter(name, tags...)
+	m.metrics[key] = c
 	return c
 }
 
-func (m *Collection) GetOrRegisterGauge(name string) *Gauge {
+func (m *Collection) GetOrRegisterGauge(name string, tags ...Tag) *Gauge {
 	mu.Lock()
 	defer mu.Unlock()
-	if c, ok := m.metrics[name]; ok {
+	key := metricKey(name, tags)
+	if c, ok := m.metrics[key]; ok {
 		return c.(*Gauge)
 	}
-	c := NewGauge(name)
-	m.metrics[name] = c
+	c := NewGauge(name, tags...)
+	m.metrics[key] = c
 	return c
 }
 
-func (m *Collection) GetOrRegisterGaugeFloat64(name string) *GaugeFloat64 {
+func (m *Collection) GetOrRegisterGaugeFloat64(name string, tags ...Tag) *GaugeFloat64 {
 	mu.Lock()
 	defer mu.Unlock()
-	if c, ok := m.metrics[name]; ok {
+	key := metricKey(name, tags)
+	if c, ok := m.metrics[key]; ok {
 		return c.(*GaugeFloat64)
 	}
-	c := NewGaugeFloat64(name)
-	m.metrics[name] = c
+	c := NewGaugeFloat64(name, tags...)
+	m.metrics[key] = c
+	return c
+}
+
+// GetOrRegisterHistogram returns the histogram for name and tags, creating
+// it with buckets if needed.  If buckets is nil, DefaultBuckets are used.
+func (m *Collection) GetOrRegisterHistogram(name string, buckets []float64, tags ...Tag) *Histogram {
+	mu.Lock()
+	defer mu.Unlock()
+	key := metricKey(name, tags)
+	if c, ok := m.metrics[key]; ok {
+		return c.(*Histogram)
+	}
+	c := NewHistogram(name, buckets, tags...)
+	m.metrics[key] = c
 	return c
 }
 
-func NewCounter(name string) *Counter {
+// GetOrRegisterSummary returns the summary for name and tags, creating it
+// with quantiles if needed.  If quantiles is nil, DefaultQuantiles are used.
+func (m *Collection) GetOrRegisterSummary(name string, quantiles []float64, tags ...Tag) *Summary {
+	mu.Lock()
+	defer mu.Unlock()
+	key := metricKey(name, tags)
+	if c, ok := m.metrics[key]; ok {
+		return c.(*Summary)
+	}
+	c := NewSummary(name, quantiles, tags...)
+	m.metrics[key] = c
+	return c
+}
+
+func NewCounter(name string, tags ...Tag) *Counter {
 	return &Counter{
 		name: name,
+		tags: tags,
 	}
 }
 
 func (c *Counter) Snapshot() Metric {
-	snap := NewCounter(c.name)
+	c.Lock()
+	defer c.Unlock()
+	snap := NewCounter(c.name, c.tags...)
 	snap.v = c.v
+	snap.ts = c.ts
 	return snap
 }
 
@@ -100,18 +221,30 @@ func (c *Counter) Reset() {
 	c.Lock()
 	defer c.Unlock()
 	c.v = 0
+	c.ts = time.Time{}
 }
 
 func (c *Counter) Inc(value int64) {
 	c.Lock()
 	defer c.Unlock()
 	c.v += value
+	c.ts = time.Now()
 }
 
 func (c *Counter) Name() string {
 	return c.name
 }
 
+func (c *Counter) Tags() Tags {
+	return c.tags
+}
+
+func (c *Counter) Time() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.ts
+}
+
 func (c *Counter) Value() interface{} {
 	c.Lock()
 	defer c.Unlock()
@@ -122,17 +255,23 @@ type Gauge struct {
 	sync.Mutex
 	v    int64
 	name string
+	tags Tags
+	ts   time.Time
 }
 
-func NewGauge(name string) *Gauge {
+func NewGauge(name string, tags ...Tag) *Gauge {
 	return &Gauge{
 		name: name,
+		tags: tags,
 	}
 }
 
 func (c *Gauge) Snapshot() Metric {
-	snap := NewGauge(c.name)
+	c.Lock()
+	defer c.Unlock()
+	snap := NewGauge(c.name, c.tags...)
 	snap.v = c.v
+	snap.ts = c.ts
 	return snap
 }
 
@@ -140,11 +279,13 @@ func (c *Gauge) Reset() {
 	c.Lock()
 	defer c.Unlock()
 	c.v = 0
+	c.ts = time.Time{}
 }
 
 func (c *Gauge) Set(value int64) {
 	c.Lock()
 	c.v = value
+	c.ts = time.Now()
 	c.Unlock()
 }
 
@@ -159,21 +300,37 @@ func (c *Gauge) Name() string {
 	return c.name
 }
 
+func (c *Gauge) Tags() Tags {
+	return c.tags
+}
+
+func (c *Gauge) Time() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.ts
+}
+
 type GaugeFloat64 struct {
 	sync.Mutex
 	v    float64
 	name string
+	tags Tags
+	ts   time.Time
 }
 
-func NewGaugeFloat64(name string) *GaugeFloat64 {
+func NewGaugeFloat64(name string, tags ...Tag) *GaugeFloat64 {
 	return &GaugeFloat64{
 		name: name,
+		tags: tags,
 	}
 }
 
 func (c *GaugeFloat64) Snapshot() Metric {
-	snap := NewGaugeFloat64(c.name)
+	c.Lock()
+	defer c.Unlock()
+	snap := NewGaugeFloat64(c.name, c.tags...)
 	snap.v = c.v
+	snap.ts = c.ts
 	return snap
 }
 
@@ -181,12 +338,14 @@ func (c *GaugeFloat64) Reset() {
 	c.Lock()
 	defer c.Unlock()
 	c.v = 0
+	c.ts = time.Time{}
 }
 
 func (c *GaugeFloat64) Set(value float64) {
 	c.Lock()
 	defer c.Unlock()
 	c.v = value
+	c.ts = time.Now()
 }
 
 func (c *GaugeFloat64) Value() interface{} {
@@ -198,3 +357,13 @@ func (c *GaugeFloat64) Value() interface{} {
 func (c *GaugeFloat64) Name() string {
 	return c.name
 }
+
+func (c *GaugeFloat64) Tags() Tags {
+	return c.tags
+}
+
+func (c *GaugeFloat64) Time() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.ts
+}
@@ -1,7 +1,10 @@
 package metrics
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCounterAdd(t *testing.T) {
@@ -66,3 +69,132 @@ func TestGaugeFlat64Set(t *testing.T) {
 		t.Fatalf("expected 21, got %d", c.Value())
 	}
 }
+
+func TestTagsFlatten(t *testing.T) {
+	tags := Tags{PathTag("container", "web.1"), NewTag("image", "nginx")}
+	if name := tags.Flatten("docker.mem.rss"); name != "docker.mem.rss.web_1" {
+		t.Fatalf("expected docker.mem.rss.web_1, got %s", name)
+	}
+
+	if name := Tags(nil).Flatten("docker.containers"); name != "docker.containers" {
+		t.Fatalf("expected docker.containers, got %s", name)
+	}
+}
+
+func TestCollectionTaggedMetrics(t *testing.T) {
+	col := NewCollection()
+	col.GetOrRegisterCounter("docker.logs", PathTag("container", "web")).Inc(1)
+	col.GetOrRegisterCounter("docker.logs", PathTag("container", "db")).Inc(2)
+	col.GetOrRegisterCounter("docker.logs", PathTag("container", "web")).Inc(3)
+
+	if len(col.Metrics()) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(col.Metrics()))
+	}
+
+	c := col.GetOrRegisterCounter("docker.logs", PathTag("container", "web"))
+	if c.Value().(int64) != 4 {
+		t.Fatalf("expected 4, got %d", c.Value())
+	}
+}
+
+type chanHandler struct {
+	done chan struct{}
+}
+
+func (h *chanHandler) SendForever(metrics chan *Collection) {
+	for range metrics {
+	}
+	close(h.done)
+}
+
+func TestRemoveHandler(t *testing.T) {
+	h := &chanHandler{done: make(chan struct{})}
+	AddHandler(h)
+	RemoveHandler(h)
+
+	select {
+	case <-h.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected metrics channel to be closed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := handlers[h]; ok {
+		t.Fatal("expected handler to be removed")
+	}
+}
+
+type recordingHandler struct {
+	sent []*Collection
+	done chan struct{}
+}
+
+func (h *recordingHandler) SendForever(metrics chan *Collection) {
+	for col := range metrics {
+		h.sent = append(h.sent, col)
+	}
+}
+
+func (h *recordingHandler) Shutdown() {
+	close(h.done)
+}
+
+func TestFlushAndClose(t *testing.T) {
+	h := &recordingHandler{done: make(chan struct{})}
+	AddHandler(h)
+
+	GetOrRegisterGauge("hud.test.shutdown").Set(1)
+	Flush(time.Second)
+	if err := Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-h.done:
+	default:
+		t.Fatal("expected handler to be shut down")
+	}
+
+	if len(h.sent) != 1 {
+		t.Fatalf("expected final flush, got %d collections", len(h.sent))
+	}
+	if g := h.sent[0].GetOrRegisterGauge("hud.test.shutdown"); g.Value().(int64) != 1 {
+		t.Fatalf("expected flushed gauge, got %v", g.Value())
+	}
+}
+
+func TestCollectionMarshalJSON(t *testing.T) {
+	c := NewCollection()
+	c.GetOrRegisterGauge("b.gauge", PathTag("container", "web")).Set(2)
+	c.GetOrRegisterCounter("a.counter").Inc(3)
+	c.GetOrRegisterHistogram("c.histogram", []float64{1}).Observe(0.5)
+
+	data, err := json.Marshal(c.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []struct {
+		Type  string          `json:"type"`
+		Name  string          `json:"name"`
+		Tags  Tags            `json:"tags"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != 3 {
+		t.Fatalf("expected 3 metrics, got %s", data)
+	}
+	if decoded[0].Name != "a.counter" || decoded[0].Type != "counter" || string(decoded[0].Value) != "3" {
+		t.Fatalf("unexpected counter %+v", decoded[0])
+	}
+	if decoded[1].Type != "gauge" || decoded[1].Tags.Map()["container"] != "web" || string(decoded[1].Value) != "2" {
+		t.Fatalf("unexpected gauge %+v", decoded[1])
+	}
+	if decoded[2].Type != "histogram" || !strings.Contains(string(decoded[2].Value), `"count":1`) {
+		t.Fatalf("unexpected histogram %+v", decoded[2])
+	}
+}
@@ -0,0 +1,358 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var invalidPromChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// promExpiry is how long a series is exposed after it was last updated, so
+// the series of stopped containers go away.
+const promExpiry = 5 * time.Minute
+
+// promRule maps a dotted metric name onto a Prometheus metric name and
+// labels. Each pattern segment is either a literal, "*" to keep the segment
+// as part of the metric name, or "{label}" to move the segment into a label.
+type promRule struct {
+	segments []string
+}
+
+// promRules are matched against the trailing segments of untagged metric
+// names so that any global prefix ends up as part of the metric name.  The
+// first matching rule wins.
+var promRules = []promRule{
+	newPromRule("docker.cpu.*.{container}"),
+	newPromRule("docker.mem.*.{container}"),
+	newPromRule("docker.logs.total.{container}"),
+	newPromRule("docker.logs.{stream}.{container}"),
+	newPromRule("docker.events.{status}"),
+	newPromRule("system.cpu.util.*.{cpu}"),
+	newPromRule("system.net.*.*.if.{interface}"),
+	newPromRule("system.net.*.*.ip4.{ip}"),
+	newPromRule("system.net.*.*.ip6.{ip}"),
+	newPromRule("system.disk.*.*.mount.{mount}"),
+	newPromRule("system.disk.*.*.dev.{device}"),
+}
+
+func newPromRule(pattern string) promRule {
+	return promRule{segments: strings.Split(pattern, ".")}
+}
+
+// match returns the metric name parts and labels for name if the rule
+// matches.
+func (r promRule) match(parts []string) ([]string, [][2]string, bool) {
+	if len(parts) < len(r.segments) {
+		return nil, nil, false
+	}
+
+	offset := len(parts) - len(r.segments)
+	name := append([]string{}, parts[:offset]...)
+	labels := [][2]string{}
+	for i, seg := range r.segments {
+		part := parts[offset+i]
+		switch {
+		case seg == "*":
+			name = append(name, part)
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			labels = append(labels, [2]string{seg[1 : len(seg)-1], part})
+		case seg == part:
+			name = append(name, part)
+		default:
+			return nil, nil, false
+		}
+	}
+	return name, labels, true
+}
+
+// promSeries is a single Prometheus time series.  Histograms and summaries
+// expose several series per metric which share a family name and group.
+type promSeries struct {
+	family string
+	group  string
+	name   string
+	labels string
+	kind   string
+	value  string
+}
+
+// promSummary accumulates the count and sum of a summary across flushes.
+// Quantiles are taken from the latest snapshot.
+type promSummary struct {
+	latest Metric
+	count  int64
+	sum    float64
+}
+
+// Prometheus is a Handler that keeps the latest snapshot of metrics and
+// serves it over HTTP in the Prometheus text exposition format.  Counters,
+// histograms and summary counts are accumulated across flushes so they are
+// exposed as monotonic counters.  Series that are not updated for
+// promExpiry are dropped.
+type Prometheus struct {
+	sync.Mutex
+	counters   map[string]*Counter
+	gauges     map[string]Metric
+	histograms map[string]*Histogram
+	summaries  map[string]*promSummary
+	updated    map[string]time.Time
+}
+
+func NewPrometheus() *Prometheus {
+	return &Prometheus{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]Metric),
+		histograms: make(map[string]*Histogram),
+		summaries:  make(map[string]*promSummary),
+		updated:    make(map[string]time.Time),
+	}
+}
+
+func (p *Prometheus) SendForever(metrics chan *Collection) {
+	for collection := range metrics {
+		p.update(collection)
+	}
+}
+
+func (p *Prometheus) update(collection *Collection) {
+	p.Lock()
+	defer p.Unlock()
+	now := collection.Time()
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	for key, metric := range collection.Metrics() {
+		if _, ok := p.updated[key]; !ok {
+			p.updated[key] = now
+		}
+		if ts := metric.Time(); ts.After(p.updated[key]) {
+			p.updated[key] = ts
+		}
+
+		switch m := metric.(type) {
+		case *Counter:
+			c, ok := p.counters[key]
+			if !ok {
+				c = NewCounter(metric.Name(), metric.Tags()...)
+				p.counters[key] = c
+			}
+			c.Inc(metric.Value().(int64))
+		case *Histogram:
+			h, ok := p.histograms[key]
+			if !ok {
+				h = NewHistogram(m.Name(), m.bounds, m.Tags()...)
+				p.histograms[key] = h
+			}
+			h.merge(m)
+		case *Summary:
+			s, ok := p.summaries[key]
+			if !ok {
+				s = &promSummary{}
+				p.summaries[key] = s
+			}
+			d := m.Value().(Distribution)
+			s.latest = m
+			s.count += d.Count
+			s.sum += d.Sum
+		default:
+			p.gauges[key] = metric
+		}
+	}
+	p.expire(now)
+}
+
+// expire drops the series that were not updated for promExpiry.
+func (p *Prometheus) expire(now time.Time) {
+	for key, ts := range p.updated {
+		if now.Sub(ts) <= promExpiry {
+			continue
+		}
+		delete(p.counters, key)
+		delete(p.gauges, key)
+		delete(p.histograms, key)
+		delete(p.summaries, key)
+		delete(p.updated, key)
+	}
+}
+
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(p.render())
+}
+
+func (p *Prometheus) render() []byte {
+	p.Lock()
+	series := []promSeries{}
+	for _, c := range p.counters {
+		series = append(series, newPromSeries(c, "counter", strconv.FormatInt(c.Value().(int64), 10)))
+	}
+	for _, metric := range p.gauges {
+		var value string
+		switch v := metric.Value().(type) {
+		case int64:
+			value = strconv.FormatInt(v, 10)
+		case float64:
+			value = strconv.FormatFloat(v, 'g', -1, 64)
+		default:
+			continue
+		}
+		series = append(series, newPromSeries(metric, "gauge", value))
+	}
+	for _, h := range p.histograms {
+		series = append(series, histogramSeries(h)...)
+	}
+	for _, s := range p.summaries {
+		series = append(series, summarySeries(s)...)
+	}
+	p.Unlock()
+
+	sort.Stable(byPromName(series))
+
+	var buf bytes.Buffer
+	last := ""
+	for _, s := range series {
+		if s.family != last {
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", s.family, s.kind)
+			last = s.family
+		}
+		fmt.Fprintf(&buf, "%s%s %s\n", s.name, s.labels, s.value)
+	}
+	return buf.Bytes()
+}
+
+func newPromSeries(metric Metric, kind, value string) promSeries {
+	name, labels := promIdentity(metric)
+	return promSeries{
+		family: name,
+		group:  promLabels(labels),
+		name:   name,
+		labels: promLabels(labels),
+		kind:   kind,
+		value:  value,
+	}
+}
+
+// histogramSeries returns the cumulative _bucket series, including the +Inf
+// bucket, followed by the _sum and _count series of a histogram.
+func histogramSeries(h *Histogram) []promSeries {
+	name, labels := promIdentity(h)
+	d := h.Value().(Distribution)
+
+	series := []promSeries{}
+	add := func(suffix string, l [][2]string, value string) {
+		series = append(series, promSeries{
+			family: name,
+			group:  promLabels(labels),
+			name:   name + suffix,
+			labels: promLabels(l),
+			kind:   "histogram",
+			value:  value,
+		})
+	}
+	for _, b := range d.Buckets {
+		add("_bucket", withLabel(labels, "le", promFloat(b.UpperBound)), strconv.FormatInt(b.Count, 10))
+	}
+	add("_bucket", withLabel(labels, "le", "+Inf"), strconv.FormatInt(d.Count, 10))
+	add("_sum", labels, promFloat(d.Sum))
+	add("_count", labels, strconv.FormatInt(d.Count, 10))
+	return series
+}
+
+// summarySeries returns the quantile series of the latest snapshot of a
+// summary followed by its accumulated _sum and _count series.
+func summarySeries(s *promSummary) []promSeries {
+	name, labels := promIdentity(s.latest)
+	d := s.latest.Value().(Distribution)
+
+	series := []promSeries{}
+	add := func(suffix string, l [][2]string, value string) {
+		series = append(series, promSeries{
+			family: name,
+			group:  promLabels(labels),
+			name:   name + suffix,
+			labels: promLabels(l),
+			kind:   "summary",
+			value:  value,
+		})
+	}
+	for _, q := range d.Quantiles {
+		add("", withLabel(labels, "quantile", promFloat(q.Quantile)), promFloat(q.Value))
+	}
+	add("_sum", labels, promFloat(s.sum))
+	add("_count", labels, strconv.FormatInt(s.count, 10))
+	return series
+}
+
+func withLabel(labels [][2]string, key, value string) [][2]string {
+	return append(append([][2]string{}, labels...), [2]string{key, value})
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// promIdentity returns the Prometheus metric name and labels of a metric.
+func promIdentity(metric Metric) (string, [][2]string) {
+	parts := strings.Split(metric.Name(), ".")
+	nameParts, labels := parts, [][2]string{}
+	if len(metric.Tags()) > 0 {
+		for _, tag := range metric.Tags() {
+			labels = append(labels, [2]string{tag.Key, tag.Value})
+		}
+	} else {
+		for _, rule := range promRules {
+			if n, l, ok := rule.match(parts); ok {
+				nameParts, labels = n, l
+				break
+			}
+		}
+	}
+
+	return promName(strings.Join(nameParts, "_")), labels
+}
+
+func promName(name string) string {
+	name = invalidPromChars.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func promLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := []string{}
+	for _, l := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", promName(l[0]), promLabelValue(l[1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func promLabelValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return `"` + v + `"`
+}
+
+type byPromName []promSeries
+
+func (s byPromName) Len() int      { return len(s) }
+func (s byPromName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byPromName) Less(i, j int) bool {
+	if s[i].family != s[j].family {
+		return s[i].family < s[j].family
+	}
+	return s[i].group < s[j].group
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"docker.cpu.total.web", `docker_cpu_total{container="web"}`},
+		{"host1.docker.mem.rss.web", `host1_docker_mem_rss{container="web"}`},
+		{"docker.logs.total.web", `docker_logs_total{container="web"}`},
+		{"docker.logs.stderr.web", `docker_logs{stream="stderr",container="web"}`},
+		{"system.cpu.util.user.all", `system_cpu_util_user{cpu="all"}`},
+		{"system.net.bytes.sent.if.eth0", `system_net_bytes_sent_if{interface="eth0"}`},
+		{"system.load.load1", `system_load_load1`},
+		{"docker.containers", `docker_containers`},
+	}
+
+	for _, test := range tests {
+		s := newPromSeries(NewGauge(test.name), "gauge", "1")
+		if s.name+s.labels != test.expected {
+			t.Fatalf("%s: expected %s, got %s", test.name, test.expected, s.name+s.labels)
+		}
+	}
+}
+
+func TestPrometheusTags(t *testing.T) {
+	g := NewGauge("docker.mem.rss", PathTag("container", "web.1"), NewTag("image", "nginx"))
+	s := newPromSeries(g, "gauge", "1")
+	expected := `docker_mem_rss{container="web.1",image="nginx"}`
+	if s.name+s.labels != expected {
+		t.Fatalf("expected %s, got %s", expected, s.name+s.labels)
+	}
+}
+
+func TestPrometheusCountersAccumulate(t *testing.T) {
+	p := NewPrometheus()
+
+	for i := 0; i < 3; i++ {
+		col := NewCollection()
+		col.GetOrRegisterCounter("docker.events.start").Inc(2)
+		col.GetOrRegisterGauge("docker.containers").Set(int64(i))
+		p.update(col)
+	}
+
+	out := string(p.render())
+	expected := []string{
+		"# TYPE docker_containers gauge\ndocker_containers 2\n",
+		"# TYPE docker_events counter\ndocker_events{status=\"start\"} 6\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Fatalf("expected %q in output:\n%s", e, out)
+		}
+	}
+}
+
+func TestPrometheusHistogram(t *testing.T) {
+	p := NewPrometheus()
+
+	for i := 0; i < 2; i++ {
+		col := NewCollection()
+		h := col.GetOrRegisterHistogram("docker.logs.size", []float64{10, 100}, NewTag("container", "web"))
+		h.Observe(5)
+		h.Observe(500)
+		col.GetOrRegisterSummary("hud.sink.duration", []float64{0.5}).Observe(2)
+		p.update(col.Snapshot())
+	}
+
+	out := string(p.render())
+	expected := strings.Join([]string{
+		`# TYPE docker_logs_size histogram`,
+		`docker_logs_size_bucket{container="web",le="10"} 2`,
+		`docker_logs_size_bucket{container="web",le="100"} 2`,
+		`docker_logs_size_bucket{container="web",le="+Inf"} 4`,
+		`docker_logs_size_sum{container="web"} 1010`,
+		`docker_logs_size_count{container="web"} 4`,
+		`# TYPE hud_sink_duration summary`,
+		`hud_sink_duration{quantile="0.5"} 2`,
+		`hud_sink_duration_sum 4`,
+		`hud_sink_duration_count 2`,
+	}, "\n")
+	if !strings.Contains(out, expected) {
+		t.Fatalf("expected:\n%s\nin output:\n%s", expected, out)
+	}
+}
+
+func TestPrometheusExpiresSeries(t *testing.T) {
+	p := NewPrometheus()
+	start := time.Now()
+
+	col := NewCollection()
+	col.ts = start
+	col.GetOrRegisterGauge("docker.mem.rss.web").Set(1)
+	col.GetOrRegisterGauge("docker.mem.rss.db").Set(1)
+	p.update(col)
+
+	// only web is still running
+	later := start.Add(promExpiry + time.Minute)
+	col.ts = later
+	web := col.GetOrRegisterGauge("docker.mem.rss.web")
+	web.Set(2)
+	web.ts = later
+	p.update(col)
+
+	out := string(p.render())
+	if !strings.Contains(out, `docker_mem_rss{container="web"} 2`) || strings.Contains(out, `container="db"`) {
+		t.Fatalf("expected only the updated series, got:\n%s", out)
+	}
+}
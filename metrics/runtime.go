@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// gcPauseBuckets are histogram buckets suited to GC pauses, from 10us to
+// about 160ms.
+var gcPauseBuckets = ExponentialBuckets(0.00001, 4, 8)
+
+// CollectRuntimeForever records Go runtime stats of hud every interval
+// seconds until ctx is cancelled.
+func CollectRuntimeForever(ctx context.Context, interval int) {
+	var lastGC uint32
+	for {
+		lastGC = collectRuntime(lastGC)
+		select {
+		case <-time.After(time.Duration(interval) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collectRuntime records the current runtime stats and the GC pauses since
+// the lastGC'th collection.  It returns the number of completed collections.
+func collectRuntime(lastGC uint32) uint32 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	Self.RecordGauge("hud.runtime.goroutines", int64(runtime.NumGoroutine()))
+	Self.RecordGauge("hud.runtime.heap.alloc", int64(stats.HeapAlloc))
+	Self.RecordGauge("hud.runtime.heap.inuse", int64(stats.HeapInuse))
+	Self.RecordGauge("hud.runtime.heap.objects", int64(stats.HeapObjects))
+	Self.RecordGauge("hud.runtime.heap.sys", int64(stats.HeapSys))
+
+	// PauseNs is a ring buffer of the most recent pauses
+	n := stats.NumGC - lastGC
+	if n > uint32(len(stats.PauseNs)) {
+		n = uint32(len(stats.PauseNs))
+	}
+	Self.RecordCount("hud.runtime.gc.count", int64(stats.NumGC-lastGC))
+	for i := uint32(0); i < n; i++ {
+		pause := stats.PauseNs[(stats.NumGC-i+255)%256]
+		Self.RecordHistogram("hud.runtime.gc.pause", gcPauseBuckets, time.Duration(pause).Seconds())
+	}
+	return stats.NumGC
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestCollectRuntime(t *testing.T) {
+	runtime.GC()
+	numGC := collectRuntime(0)
+	if numGC == 0 {
+		t.Fatalf("expected at least one GC")
+	}
+
+	if GetOrRegisterGauge("hud.runtime.goroutines").Value().(int64) <= 0 {
+		t.Fatalf("expected goroutines to be recorded")
+	}
+
+	pauses := GetOrRegisterHistogram("hud.runtime.gc.pause", gcPauseBuckets).Value().(Distribution)
+	if pauses.Count != int64(numGC) {
+		t.Fatalf("expected %d gc pauses, got %d", numGC, pauses.Count)
+	}
+
+	runtime.GC()
+	collectRuntime(numGC)
+	pauses = GetOrRegisterHistogram("hud.runtime.gc.pause", gcPauseBuckets).Value().(Distribution)
+	if pauses.Count <= int64(numGC) {
+		t.Fatalf("expected new gc pauses to be recorded")
+	}
+}
@@ -0,0 +1,444 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	spoolQueueSize  = 10
+	maxSpoolBackoff = 60 * time.Second
+
+	// spoolDrainTimeout bounds the last delivery attempt of a handler
+	// removed outside of Close.
+	spoolDrainTimeout = 5 * time.Second
+)
+
+// SpoolConfig configures the on-disk queue of a spooled handler.  Once the
+// spooled collections of a handler exceed MaxBytes, the oldest ones are
+// dropped.
+type SpoolConfig struct {
+	Dir      string
+	MaxBytes int64
+}
+
+// spool persists flushed collections to disk and replays them, in order, to
+// a handler.  Collections are only removed from disk once they have been
+// delivered.
+type spool struct {
+	sync.Mutex
+	name     string
+	dir      string
+	maxBytes int64
+	seq      uint64
+	handler  Handler
+	sendChan chan *Collection
+	in       chan *Collection
+	ready    chan struct{}
+	done     chan struct{}
+}
+
+type spooledCollection struct {
+	Time    time.Time       `json:"time"`
+	Metrics []spooledMetric `json:"metrics"`
+}
+
+type spooledMetric struct {
+	Type  string    `json:"type"`
+	Name  string    `json:"name"`
+	Tags  Tags      `json:"tags,omitempty"`
+	Time  time.Time `json:"time"`
+	Int   int64     `json:"int,omitempty"`
+	Float float64   `json:"float,omitempty"`
+
+	// Dist is the value of histograms and summaries.
+	Dist *Distribution `json:"dist,omitempty"`
+}
+
+func newSpool(name string, handler Handler, config SpoolConfig) (*spool, error) {
+	s := &spool{
+		name:     name,
+		dir:      filepath.Join(config.Dir, name),
+		maxBytes: config.MaxBytes,
+		handler:  handler,
+		in:       make(chan *Collection, spoolQueueSize),
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create spool dir: %s", err)
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		log.Infof("Replaying %d spooled %s metric batches", len(files), name)
+		last := files[len(files)-1].Name()
+		s.seq, _ = strconv.ParseUint(strings.TrimSuffix(last, ".json"), 10, 64)
+	}
+
+	if _, ok := handler.(Sender); !ok {
+		s.sendChan = make(chan *Collection)
+		go handler.SendForever(s.sendChan)
+	}
+	return s, nil
+}
+
+// spoolForever persists collections as they are flushed until the handler
+// is removed.
+func (s *spool) spoolForever() {
+	defer close(s.done)
+	for collection := range s.in {
+		if err := s.write(collection); err != nil {
+			log.Errorf("ERROR: Unable to spool %s metrics: %s", s.name, err)
+			s.recordDropped(1)
+			continue
+		}
+
+		select {
+		case s.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sendForever delivers spooled collections to the handler, oldest first,
+// retrying with a backoff until each one is delivered.  When the handler is
+// removed, it makes a last attempt to deliver what is left.
+func (s *spool) sendForever() {
+	if s.sendChan != nil {
+		defer close(s.sendChan)
+	}
+	defer s.drain()
+
+	backoff := time.Second
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		files, err := s.files()
+		if err != nil {
+			log.Errorf("ERROR: %s", err)
+			if !s.sleep(backoff) {
+				return
+			}
+			continue
+		}
+		s.recordDepth(files)
+
+		if len(files) == 0 {
+			select {
+			case <-s.ready:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+
+		path := filepath.Join(s.dir, files[0].Name())
+		collection, err := readSpooled(path)
+		if os.IsNotExist(err) {
+			// evicted while reading
+			continue
+		}
+		if err != nil {
+			log.Errorf("ERROR: Dropping unreadable spool file %s: %s", path, err)
+			os.Remove(path)
+			s.recordDropped(1)
+			continue
+		}
+
+		if err := s.deliver(collection, nil); err != nil {
+			log.Errorf("ERROR: Unable to send %s metrics, retrying in %s: %s", s.name, backoff, err)
+			if !s.sleep(backoff) {
+				return
+			}
+			backoff *= 2
+			if backoff > maxSpoolBackoff {
+				backoff = maxSpoolBackoff
+			}
+			continue
+		}
+
+		backoff = time.Second
+		os.Remove(path)
+	}
+}
+
+// sleep waits for d and returns false if the handler was removed meanwhile.
+func (s *spool) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// drain delivers the collections left once the handler is removed, without
+// retrying, until the deadline of Close.  Collections that are not delivered
+// stay on disk for the next start.
+func (s *spool) drain() {
+	mu.Lock()
+	deadline := closeDeadline
+	mu.Unlock()
+	if deadline.IsZero() {
+		deadline = time.Now().Add(spoolDrainTimeout)
+	}
+
+	for {
+		files, err := s.files()
+		if err != nil {
+			log.Errorf("ERROR: %s", err)
+			return
+		}
+		if len(files) == 0 {
+			return
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			log.Warnf("Timed out sending spooled %s metrics.  %d batches left in the spool.", s.name, len(files))
+			return
+		}
+
+		path := filepath.Join(s.dir, files[0].Name())
+		collection, err := readSpooled(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Errorf("ERROR: Dropping unreadable spool file %s: %s", path, err)
+			os.Remove(path)
+			s.recordDropped(1)
+			continue
+		}
+
+		if err := s.deliver(collection, time.After(remaining)); err != nil {
+			log.Errorf("ERROR: Unable to send %s metrics.  %d batches left in the spool: %s", s.name, len(files), err)
+			return
+		}
+		os.Remove(path)
+	}
+}
+
+// deliver sends a collection to the handler.  Handlers that are not Senders
+// are given until timeout to receive it; a nil timeout waits forever.
+func (s *spool) deliver(collection *Collection, timeout <-chan time.Time) error {
+	if sender, ok := s.handler.(Sender); ok {
+		return sender.Send(collection)
+	}
+	select {
+	case s.sendChan <- collection:
+		return nil
+	case <-timeout:
+		return fmt.Errorf("timed out")
+	}
+}
+
+// write persists a collection and drops the oldest collections if the spool
+// exceeds its maximum size.
+func (s *spool) write(collection *Collection) error {
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := json.Marshal(newSpooledCollection(collection))
+	if err != nil {
+		return err
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d.json", s.seq)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+
+	if s.maxBytes <= 0 {
+		return nil
+	}
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	size := int64(0)
+	for _, f := range files {
+		size += f.Size()
+	}
+
+	dropped := 0
+	for len(files) > 1 && size > s.maxBytes {
+		if err := os.Remove(filepath.Join(s.dir, files[0].Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		size -= files[0].Size()
+		files = files[1:]
+		dropped++
+	}
+
+	if dropped > 0 {
+		log.Warnf("Spool for %s metrics is full.  Dropped %d oldest batches.", s.name, dropped)
+		s.recordDropped(int64(dropped))
+	}
+	return nil
+}
+
+// files returns the spooled collections, oldest first.
+func (s *spool) files() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []os.FileInfo{}
+	for _, f := range entries {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func (s *spool) recordDepth(files []os.FileInfo) {
+	size := int64(0)
+	for _, f := range files {
+		size += f.Size()
+	}
+	tag := PathTag("sink", s.name)
+	Self.RecordGauge("hud.spool.depth", int64(len(files)), tag)
+	Self.RecordGauge("hud.spool.bytes", size, tag)
+}
+
+func (s *spool) recordDropped(n int64) {
+	Self.RecordCount("hud.spool.dropped", n, PathTag("sink", s.name))
+}
+
+func newSpooledCollection(collection *Collection) *spooledCollection {
+	sc := &spooledCollection{
+		Time: collection.Time(),
+	}
+
+	for _, metric := range collection.Metrics() {
+		sm := spooledMetric{
+			Name: metric.Name(),
+			Tags: metric.Tags(),
+			Time: metric.Time(),
+		}
+
+		sm.Type = metricType(metric)
+		switch m := metric.(type) {
+		case *Counter, *Gauge:
+			sm.Int = m.Value().(int64)
+		case *GaugeFloat64:
+			sm.Float = m.Value().(float64)
+		case *Histogram, *Summary:
+			d := m.Value().(Distribution)
+			sm.Dist = &d
+		default:
+			log.Errorf("Unhandled spool type: %T", metric)
+			continue
+		}
+		sc.Metrics = append(sc.Metrics, sm)
+	}
+	return sc
+}
+
+func readSpooled(path string) (*Collection, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc spooledCollection
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, err
+	}
+
+	collection := NewCollection()
+	collection.ts = sc.Time
+	for _, sm := range sc.Metrics {
+		var metric Metric
+		switch sm.Type {
+		case "counter":
+			c := NewCounter(sm.Name, sm.Tags...)
+			c.v, c.ts = sm.Int, sm.Time
+			metric = c
+		case "gauge":
+			g := NewGauge(sm.Name, sm.Tags...)
+			g.v, g.ts = sm.Int, sm.Time
+			metric = g
+		case "gauge_float64":
+			g := NewGaugeFloat64(sm.Name, sm.Tags...)
+			g.v, g.ts = sm.Float, sm.Time
+			metric = g
+		case "histogram":
+			if sm.Dist == nil {
+				return nil, fmt.Errorf("histogram %s has no value", sm.Name)
+			}
+			metric = restoreHistogram(sm)
+		case "summary":
+			if sm.Dist == nil {
+				return nil, fmt.Errorf("summary %s has no value", sm.Name)
+			}
+			metric = restoreSummary(sm)
+		default:
+			return nil, fmt.Errorf("unknown metric type: %s", sm.Type)
+		}
+		collection.metrics[metricKey(sm.Name, sm.Tags)] = metric
+	}
+	return collection, nil
+}
+
+func restoreHistogram(sm spooledMetric) *Histogram {
+	bounds := []float64{}
+	for _, b := range sm.Dist.Buckets {
+		bounds = append(bounds, b.UpperBound)
+	}
+
+	h := NewHistogram(sm.Name, bounds, sm.Tags...)
+	for i, b := range sm.Dist.Buckets {
+		h.counts[i] = b.Count
+	}
+	h.count, h.sum, h.ts = sm.Dist.Count, sm.Dist.Sum, sm.Time
+
+	// the maximum is not spooled, the highest quantile is the best estimate
+	for _, q := range sm.Dist.Quantiles {
+		if q.Value > h.max {
+			h.max = q.Value
+		}
+	}
+	return h
+}
+
+func restoreSummary(sm spooledMetric) *Summary {
+	quantiles := []float64{}
+	for _, q := range sm.Dist.Quantiles {
+		quantiles = append(quantiles, q.Quantile)
+	}
+
+	s := NewSummary(sm.Name, quantiles, sm.Tags...)
+	s.count, s.sum, s.ts = sm.Dist.Count, sm.Dist.Sum, sm.Time
+	s.frozen = sm.Dist
+	return s
+}
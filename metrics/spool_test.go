@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type flakySender struct {
+	sync.Mutex
+	failures int
+	sent     []*Collection
+	done     chan struct{}
+}
+
+func (f *flakySender) SendForever(metrics chan *Collection) {}
+
+func (f *flakySender) Send(col *Collection) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		return fmt.Errorf("sink down")
+	}
+	f.sent = append(f.sent, col)
+	f.done <- struct{}{}
+	return nil
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool("test", &flakySender{}, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := NewCollection()
+	col.ts = time.Unix(1000, 0)
+	col.GetOrRegisterCounter("docker.logs", PathTag("container", "web")).Inc(5)
+	col.GetOrRegisterGaugeFloat64("system.load.load1").Set(1.5)
+	col.GetOrRegisterHistogram("docker.logs.size", []float64{10, 100}).Observe(50)
+	if err := s.write(col); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := s.files()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected 1 spooled file, got %d: %v", len(files), err)
+	}
+
+	restored, err := readSpooled(s.dir + "/" + files[0].Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !restored.Time().Equal(col.Time()) {
+		t.Fatalf("expected time %s, got %s", col.Time(), restored.Time())
+	}
+
+	c := restored.GetOrRegisterCounter("docker.logs", PathTag("container", "web"))
+	if c.Value().(int64) != 5 || c.Tags()[0].Value != "web" {
+		t.Fatalf("unexpected counter: %v %v", c.Value(), c.Tags())
+	}
+
+	g := restored.GetOrRegisterGaugeFloat64("system.load.load1")
+	if g.Value().(float64) != 1.5 {
+		t.Fatalf("expected 1.5, got %v", g.Value())
+	}
+
+	d := restored.GetOrRegisterHistogram("docker.logs.size", nil).Value().(Distribution)
+	if d.Count != 1 || d.Sum != 50 || len(d.Buckets) != 2 || d.Buckets[1].Count != 1 {
+		t.Fatalf("unexpected histogram: %+v", d)
+	}
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool("test", &flakySender{}, SpoolConfig{Dir: dir, MaxBytes: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		col := NewCollection()
+		col.GetOrRegisterGauge("docker.containers").Set(int64(i))
+		if err := s.write(col); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := s.files()
+	if len(files) == 0 || len(files) == 10 {
+		t.Fatalf("expected oldest files to be dropped, got %d files", len(files))
+	}
+
+	if files[len(files)-1].Name() != fmt.Sprintf("%020d.json", 10) {
+		t.Fatalf("expected newest file to be kept, got %s", files[len(files)-1].Name())
+	}
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// spool while the sink is unavailable
+	s, err := newSpool("test", &flakySender{}, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		col := NewCollection()
+		col.ts = time.Unix(int64(i), 0)
+		if err := s.write(col); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// replay after a restart
+	sender := &flakySender{failures: 1, done: make(chan struct{}, 3)}
+	s, err = newSpool("test", sender, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.sendForever()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-sender.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for replay")
+		}
+	}
+
+	sender.Lock()
+	defer sender.Unlock()
+	for i, col := range sender.sent {
+		if col.Time().Unix() != int64(i) {
+			t.Fatalf("expected batch %d, got %d", i, col.Time().Unix())
+		}
+	}
+}
+
+func TestSpoolDeliversOnRemove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sender := &flakySender{done: make(chan struct{}, 2)}
+	s, err := newSpool("test", sender, SpoolConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(NewCollection()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last flush is queued as the handler is removed
+	s.in <- NewCollection()
+	close(s.in)
+	s.spoolForever()
+	s.sendForever()
+
+	if len(sender.sent) != 2 {
+		t.Fatalf("expected 2 batches to be delivered, got %d", len(sender.sent))
+	}
+	files, err := s.files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected the spool to be empty, got %d files", len(files))
+	}
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DefaultStatsDMTU is the default maximum size of a StatsD packet.  It keeps
+// packets below a typical ethernet MTU once IP and UDP headers are added.
+const DefaultStatsDMTU = 1432
+
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "\n", "_")
+var statsdTagReplacer = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "\n", "_")
+
+// StatsD sends metrics to a StatsD or DogStatsD agent over UDP.  The StatsD
+// protocol has no timestamps so metrics are timestamped by the agent when
+// they are received.
+type StatsD struct {
+	addr   string
+	mtu    int
+	tagged bool
+	conn   net.Conn
+}
+
+// NewStatsD returns a StatsD handler sending to addr in packets of at most
+// mtu bytes.  If tagged is true, metric tags are sent using the DogStatsD
+// tag extension.  Otherwise, metrics are sent using their flat names.
+func NewStatsD(addr string, mtu int, tagged bool) (*StatsD, error) {
+	if mtu <= 0 {
+		mtu = DefaultStatsDMTU
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsD{
+		addr:   addr,
+		mtu:    mtu,
+		tagged: tagged,
+		conn:   conn,
+	}, nil
+}
+
+func (s *StatsD) SendForever(metrics chan *Collection) {
+	for collection := range metrics {
+		if err := s.Send(collection); err != nil {
+			log.Errorf("ERROR: statsd %s: %s", s.addr, err)
+		}
+	}
+}
+
+// Shutdown closes the StatsD socket.  It is called once the handler is
+// removed or hud shuts down.
+func (s *StatsD) Shutdown() {
+	s.conn.Close()
+}
+
+// Send writes the collection in as few packets as possible.
+func (s *StatsD) Send(collection *Collection) (err error) {
+	defer func(start time.Time) { recordSend("statsd", start, err) }(time.Now())
+
+	var buf bytes.Buffer
+	for _, metric := range collection.Metrics() {
+		for _, line := range s.format(metric) {
+			if buf.Len() > 0 && buf.Len()+len(line)+1 > s.mtu {
+				if err := s.send(buf.Bytes()); err != nil {
+					return err
+				}
+				buf.Reset()
+			}
+
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(line)
+		}
+	}
+
+	if buf.Len() > 0 {
+		return s.send(buf.Bytes())
+	}
+	return nil
+}
+
+// format returns the StatsD lines for a metric.  Histograms and summaries
+// are sent as a .count counter and .sum and .pNN gauges.
+func (s *StatsD) format(metric Metric) []string {
+	kind := "g"
+	if _, ok := metric.(*Counter); ok {
+		kind = "c"
+	}
+
+	switch v := metric.Value().(type) {
+	case int64, float64:
+		return []string{s.line(metric, "", v, kind)}
+	case Distribution:
+		lines := []string{}
+		for _, f := range v.fields() {
+			kind := "g"
+			if f.name == "count" {
+				kind = "c"
+			}
+			lines = append(lines, s.line(metric, f.name, f.value, kind))
+		}
+		return lines
+	default:
+		log.Errorf("Unhandled statsd type: %v", v)
+		return nil
+	}
+}
+
+func (s *StatsD) line(metric Metric, suffix string, v interface{}, kind string) string {
+	var value string
+	switch v := v.(type) {
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	name := metric.Name()
+	if !s.tagged {
+		name = metric.Tags().Flatten(name)
+	}
+	if suffix != "" {
+		name += "." + suffix
+	}
+
+	line := fmt.Sprintf("%s:%s|%s", statsdReplacer.Replace(name), value, kind)
+	if s.tagged && len(metric.Tags()) > 0 {
+		tags := []string{}
+		for _, tag := range metric.Tags() {
+			tags = append(tags, statsdTagReplacer.Replace(tag.Key)+":"+statsdTagReplacer.Replace(tag.Value))
+		}
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+func (s *StatsD) send(packet []byte) error {
+	log.Debug(string(packet))
+	_, err := s.conn.Write(packet)
+	return err
+}
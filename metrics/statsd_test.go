@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDFormat(t *testing.T) {
+	s := &StatsD{}
+
+	c := NewCounter("docker.logs", PathTag("stream", "stdout"), PathTag("container", "web"))
+	c.Inc(3)
+	if line := s.format(c)[0]; line != "docker.logs.stdout.web:3|c" {
+		t.Fatalf("expected flat counter, got %s", line)
+	}
+
+	g := NewGaugeFloat64("docker.cpu.total", PathTag("container", "web"), NewTag("image", "nginx:1.9"))
+	g.Set(1.5)
+	s.tagged = true
+	if line := s.format(g)[0]; line != "docker.cpu.total:1.5|g|#container:web,image:nginx_1.9" {
+		t.Fatalf("expected tagged gauge, got %s", line)
+	}
+
+	h := NewSummary("hud.sink.duration", []float64{0.5}, NewTag("sink", "graphite"))
+	h.Observe(2)
+	lines := s.format(h)
+	expected := []string{
+		"hud.sink.duration.count:1|c|#sink:graphite",
+		"hud.sink.duration.sum:2|g|#sink:graphite",
+		"hud.sink.duration.p50:2|g|#sink:graphite",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestStatsDBatching(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := NewStatsD(conn.LocalAddr().String(), 64, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := NewCollection()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		col.GetOrRegisterGauge("hud.test.gauge." + name).Set(1)
+	}
+
+	metrics := make(chan *Collection)
+	done := make(chan struct{})
+	go func() {
+		s.SendForever(metrics)
+		s.Shutdown()
+		close(done)
+	}()
+	defer func() {
+		close(metrics)
+		<-done
+	}()
+	metrics <- col
+
+	lines := 0
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for lines < 6 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > 64 {
+			t.Fatalf("packet exceeds mtu: %d", n)
+		}
+		lines += len(strings.Split(string(buf[:n]), "\n"))
+	}
+
+	if lines != 6 {
+		t.Fatalf("expected 6 lines, got %d", lines)
+	}
+}
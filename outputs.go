@@ -0,0 +1,372 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jwilder/hud/config"
+	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/logger"
+	"github.com/jwilder/hud/metrics"
+)
+
+// sink is a metrics handler that can be created from the current settings.
+// Sinks with the same key have the same settings and are kept on reload.
+// If addr is set, the handler is served over HTTP at addr/metrics.
+type sink struct {
+	name   string
+	key    string
+	addr   string
+	create func() (metrics.Handler, error)
+}
+
+// outputs tracks the log destinations and metrics sinks in use so they can
+// be replaced when the configuration is reloaded, without restarting
+// collectors or re-attaching to containers.
+type outputs struct {
+	sync.Mutex
+	dockerC   *docker.Collectors
+	daemons   []daemon
+	restart   string
+	cliFlags  map[string]bool
+	logs      map[string]docker.LogHandler
+	sinks     map[string]metrics.Handler
+	listeners map[string]net.Listener
+}
+
+// newOutputs must be called after the flags are parsed.
+func newOutputs() *outputs {
+	o := &outputs{
+		cliFlags:  map[string]bool{},
+		logs:      map[string]docker.LogHandler{},
+		sinks:     map[string]metrics.Handler{},
+		listeners: map[string]net.Listener{},
+	}
+	flag.Visit(func(f *flag.Flag) {
+		o.cliFlags[f.Name] = true
+	})
+	return o
+}
+
+// Reload re-reads the config file and replaces the outputs whose settings
+// changed.  If the config file is invalid, the current outputs are kept.
+func (o *outputs) Reload() error {
+	o.Lock()
+	defer o.Unlock()
+
+	var cfg *config.Config
+	if opts.configFile != "" {
+		var err error
+		cfg, err = config.Load(opts.configFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	st, err := loadSettings(os.Args[1:], cfg)
+	if err != nil {
+		return err
+	}
+	if st.statsPrefix != opts.statsPrefix || st.flushInterval != opts.flushInterval || st.noStats != opts.noStats || st.httpAddr != opts.httpAddr || st.stateFile != opts.stateFile || st.maxLineSize != opts.maxLineSize {
+		log.Warn("Changes to prefix, flush-interval, no-stats, http-addr, state-file and max-line-size require a restart")
+		st.statsPrefix, st.flushInterval, st.noStats, st.httpAddr, st.stateFile, st.maxLineSize = opts.statsPrefix, opts.flushInterval, opts.noStats, opts.httpAddr, opts.stateFile, opts.maxLineSize
+	}
+	daemons, err := parseDaemons(st.dockerEndpoints, cfg)
+	if err != nil {
+		return err
+	}
+	if fmt.Sprint(daemons) != fmt.Sprint(o.daemons) || st.dockerTLS != opts.dockerTLS {
+		log.Warn("Changes to the docker endpoints and TLS settings require a restart")
+	}
+	if restartSettings(cfg) != o.restart {
+		log.Warn("Changes to the container selection, multiline, log rules, redaction, rate limits and parsing require a restart")
+	}
+	if st.hostname == "" {
+		st.hostname, _ = os.Hostname()
+	}
+
+	log.Info("Reloading log destinations and metrics sinks")
+	return o.apply(cfg, st)
+}
+
+// Apply adds and removes outputs to match the settings.
+func (o *outputs) Apply(cfg *config.Config, st settings) error {
+	o.Lock()
+	defer o.Unlock()
+	return o.apply(cfg, st)
+}
+
+func (o *outputs) apply(cfg *config.Config, st settings) error {
+	sinks := []sink{}
+	if !st.noStats {
+		sinks = metricsSinks(st)
+	}
+
+	dests := []logDestination{}
+	if !st.noLogs {
+		var err error
+		if cfg != nil && len(cfg.Logs) > 0 && !o.cliFlags["log-to"] {
+			dests, err = configLogDestinations(cfg)
+		} else {
+			dests, err = parseLogDestinations(st.logDests, st.logFmts)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse log destinations: %s", err)
+		}
+	}
+
+	// create everything first so a bad setting leaves the outputs unchanged
+	newSinks := map[string]metrics.Handler{}
+	for _, s := range sinks {
+		if _, ok := o.sinks[s.key]; ok {
+			continue
+		}
+		log.Infof("Sending metrics to %s", s.name)
+		handler, err := s.create()
+		if err == nil && s.addr != "" {
+			err = o.serve(s.key, s.addr, handler)
+		}
+		if err != nil {
+			o.closeListeners(newSinks)
+			return fmt.Errorf("%s: %s", s.name, err)
+		}
+		newSinks[s.key] = handler
+	}
+
+	newLogs := map[string]docker.LogHandler{}
+	for _, dest := range dests {
+		if _, ok := o.logs[dest.key]; ok {
+			continue
+		}
+		handler, err := newLogHandler(dest, st.hostname, len(o.daemons) > 1)
+		if err != nil {
+			o.closeListeners(newSinks)
+			return fmt.Errorf("%s: %s", dest.dest, err)
+		}
+		newLogs[dest.key] = handler
+	}
+
+	wanted := map[string]bool{}
+	for _, s := range sinks {
+		wanted[s.key] = true
+	}
+	for key, handler := range o.sinks {
+		if !wanted[key] {
+			o.removeSink(key, handler)
+		}
+	}
+	for key, handler := range newSinks {
+		if err := o.addSink(key, handler, st); err != nil {
+			return err
+		}
+	}
+
+	wanted = map[string]bool{}
+	for _, dest := range dests {
+		wanted[dest.key] = true
+	}
+	for key, handler := range o.logs {
+		if !wanted[key] {
+			log.Infof("Removing log destination %s", key)
+			o.dockerC.RemoveLogHandler(handler)
+			delete(o.logs, key)
+		}
+	}
+	for key, handler := range newLogs {
+		o.dockerC.AddLogHandler(handler)
+		o.logs[key] = handler
+	}
+	return nil
+}
+
+// addSink adds a metrics handler, spooling its metrics to disk if a spool
+// directory is configured.  StatsD is not spooled: a batch that fails
+// partway would be resent whole, counting counters twice, and without
+// timestamps replayed metrics would land in the current interval.
+func (o *outputs) addSink(key string, handler metrics.Handler, st settings) error {
+	name := strings.SplitN(key, " ", 2)[0]
+	if st.spoolDir == "" || name == "prometheus" || name == "statsd" {
+		metrics.AddHandler(handler)
+		o.sinks[key] = handler
+		return nil
+	}
+
+	err := metrics.AddSpooledHandler(name, handler, metrics.SpoolConfig{
+		Dir:      st.spoolDir,
+		MaxBytes: int64(st.spoolMaxSize) * 1024 * 1024,
+	})
+	if err != nil {
+		return err
+	}
+	o.sinks[key] = handler
+	return nil
+}
+
+func (o *outputs) removeSink(key string, handler metrics.Handler) {
+	log.Infof("Removing metrics sink %s", key)
+	metrics.RemoveHandler(handler)
+	delete(o.sinks, key)
+	if l, ok := o.listeners[key]; ok {
+		l.Close()
+		delete(o.listeners, key)
+	}
+}
+
+func (o *outputs) serve(key, addr string, handler metrics.Handler) error {
+	h, ok := handler.(http.Handler)
+	if !ok {
+		return fmt.Errorf("%T can not be served over http", handler)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	o.listeners[key] = l
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	go http.Serve(l, mux)
+	return nil
+}
+
+// closeListeners closes the listeners of sinks that were created but not
+// added.
+func (o *outputs) closeListeners(sinks map[string]metrics.Handler) {
+	for key := range sinks {
+		if l, ok := o.listeners[key]; ok {
+			l.Close()
+			delete(o.listeners, key)
+		}
+	}
+}
+
+// metricsSinks returns the metrics sinks enabled by the settings.
+func metricsSinks(st settings) []sink {
+	sinks := []sink{}
+	spool := fmt.Sprintf("spool=%s,%d", st.spoolDir, st.spoolMaxSize)
+
+	if st.graphiteAddr != "" {
+		sinks = append(sinks, sink{
+			name: "graphite at " + st.graphiteAddr,
+			key:  fmt.Sprintf("graphite %s flat=%t %s", st.graphiteAddr, st.flatNames, spool),
+			create: func() (metrics.Handler, error) {
+				return metrics.NewGraphite(st.graphiteAddr, 3*time.Second, st.flatNames)
+			},
+		})
+	}
+
+	if st.statsdAddr != "" {
+		sinks = append(sinks, sink{
+			name: "statsd at " + st.statsdAddr,
+			key:  fmt.Sprintf("statsd %s mtu=%d tags=%t", st.statsdAddr, st.statsdMTU, st.statsdTags),
+			create: func() (metrics.Handler, error) {
+				return metrics.NewStatsD(st.statsdAddr, st.statsdMTU, st.statsdTags)
+			},
+		})
+	}
+
+	if st.influxDBAddr != "" {
+		influxConfig := metrics.InfluxDBConfig{
+			URL:             st.influxDBAddr,
+			Database:        st.influxDBDB,
+			RetentionPolicy: st.influxDBRP,
+			Username:        st.influxDBUser,
+			Password:        st.influxDBPass,
+			Org:             st.influxDBOrg,
+			Bucket:          st.influxDBBucket,
+			Token:           st.influxDBToken,
+			Precision:       st.influxDBPrec,
+			Gzip:            st.influxDBGzip,
+			MaxRetries:      metrics.DefaultInfluxDBMaxRetries,
+			Flat:            st.flatNames,
+		}
+		sinks = append(sinks, sink{
+			name: "influxdb at " + st.influxDBAddr,
+			key:  fmt.Sprintf("influxdb %+v %s", influxConfig, spool),
+			create: func() (metrics.Handler, error) {
+				return metrics.NewInfluxDB(influxConfig)
+			},
+		})
+	}
+
+	if st.prometheusAddr != "" {
+		sinks = append(sinks, sink{
+			name: "prometheus at " + st.prometheusAddr + "/metrics",
+			key:  "prometheus " + st.prometheusAddr,
+			addr: st.prometheusAddr,
+			create: func() (metrics.Handler, error) {
+				return metrics.NewPrometheus(), nil
+			},
+		})
+	}
+	return sinks
+}
+
+// newLogHandler returns the log handler of a log destination.  If
+// showDaemon is set, the daemon of each container is included so
+// containers with the same name on several daemons can be told apart.
+func newLogHandler(dest logDestination, hostname string, showDaemon bool) (docker.LogHandler, error) {
+	var f logger.Formatter
+	f = &logger.ShortFormatter{ShowDaemon: showDaemon}
+	switch dest.format {
+	case "ext":
+		f = &logger.ExtendedFormatter{ShowDaemon: showDaemon}
+	case "json":
+		f = &logger.JSONFormatter{}
+	case "syslog":
+
+		f = &logger.SyslogFormatter{
+			Hostname:   hostname,
+			Severity:   dest.severity,
+			Facility:   dest.facility,
+			Newline:    strings.HasPrefix(dest.dest, "tcp://"),
+			ShowDaemon: showDaemon,
+		}
+	}
+
+	var handler docker.LogHandler
+	switch dest.dest {
+	case "console":
+		f.SetColored(true)
+		cl, err := logger.NewConsoleLogger(os.Stdout, f)
+		if err != nil {
+			return nil, err
+		}
+		handler = cl
+	default:
+		f.SetColored(false)
+		sl, err := logger.NewSocketLogger(dest.dest, dest.tlsConfig, f)
+		if err != nil {
+			return nil, err
+		}
+		handler = sl
+	}
+
+	if len(dest.routes) > 0 {
+		handler = logger.NewFilteredLogger(handler, dest.routes...)
+	}
+	return handler, nil
+}
+
+// ServeHTTP reloads the configuration on POST /reload.
+func (o *outputs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := o.Reload(); err != nil {
+		log.Errorf("ERROR: Unable to reload: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
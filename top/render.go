@@ -0,0 +1,161 @@
+package top
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jwilder/hud/ansi"
+	"github.com/jwilder/hud/logger"
+)
+
+// sortKeys are the keys that sort the container table, by column.
+var sortKeys = map[byte]string{
+	'n': "name",
+	'c': "cpu",
+	'm': "rss",
+	'k': "cache",
+	'l': "logs",
+}
+
+const help = "q quit  / filter  sort: n name  c cpu  m rss  k cache  l logs (again to reverse)"
+
+// render returns the screen for a terminal of width x height as lines.
+func (d *Dashboard) render(width, height int) []string {
+	d.Lock()
+	defer d.Unlock()
+
+	rows := d.rows()
+	filter := d.filter
+	if d.editing {
+		filter += "_"
+	}
+	lines := []string{
+		fmt.Sprintf("hud top - %s  containers: %d  sort: %s  filter: %s",
+			time.Now().Format("15:04:05"), len(rows), d.sortName(), filter),
+		formatHost(d.host),
+		"",
+		fmt.Sprintf("\x1b[7m%-24s %-24s %6s %8s %8s %8s %8s\x1b[0m",
+			"CONTAINER", "IMAGE", "CPU%", "RSS", "CACHE", "STDOUT/s", "STDERR/s"),
+	}
+
+	// the table gets at most half of the screen, the logs get the rest
+	tableHeight := (height - len(lines) - 3) / 2
+	for i, c := range rows {
+		if i == tableHeight {
+			break
+		}
+		name := fmt.Sprintf("%-24s", clip(c.name, 24))
+		lines = append(lines, fmt.Sprintf("\x1b[%sm%s\x1b[0m %-24s %6.1f %8s %8s %8.1f %8.1f",
+			logger.ContainerColor(c.name), name, clip(c.image, 24),
+			c.cpu, formatBytes(float64(c.rss)), formatBytes(float64(c.cache)), c.stdout, c.stderr))
+	}
+	lines = append(lines, "", "\x1b[7mLOGS\x1b[0m")
+
+	logs := d.logs(height - len(lines) - 1)
+	lines = append(lines, logs...)
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, help)
+
+	for i, line := range lines {
+		lines[i] = ansi.Truncate(line, width)
+	}
+	return lines
+}
+
+// rows returns the containers matching the filter in table order.
+func (d *Dashboard) rows() []*containerStats {
+	rows := []*containerStats{}
+	for _, c := range d.containers {
+		if d.matches(c.name) {
+			rows = append(rows, c)
+		}
+	}
+
+	less := func(a, b *containerStats) bool {
+		switch d.sortBy {
+		case 'c':
+			return a.cpu > b.cpu
+		case 'm':
+			return a.rss > b.rss
+		case 'k':
+			return a.cache > b.cache
+		case 'l':
+			return a.logRate() > b.logRate()
+		}
+		return a.name < b.name
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if less(rows[i], rows[j]) {
+			return !d.reverse
+		}
+		if less(rows[j], rows[i]) {
+			return d.reverse
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
+// logs returns the last n log lines matching the filter.
+func (d *Dashboard) logs(n int) []string {
+	lines := []string{}
+	for i := len(d.lines) - 1; i >= 0 && len(lines) < n; i-- {
+		if d.matches(d.lines[i].container) {
+			lines = append(lines, d.lines[i].text)
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+func (d *Dashboard) matches(container string) bool {
+	return strings.Contains(container, d.filter)
+}
+
+func (d *Dashboard) sortName() string {
+	name := sortKeys[d.sortBy]
+	if d.reverse {
+		return name + " (reversed)"
+	}
+	return name
+}
+
+func formatHost(h hostStats) string {
+	if !h.hasMetrics {
+		return "host: waiting for stats..."
+	}
+	return fmt.Sprintf("host: cpu %.1f%%  load %.2f %.2f %.2f  mem %s/%s  net rx %s/s tx %s/s  disk r %s/s w %s/s",
+		h.cpu, h.load[0], h.load[1], h.load[2],
+		formatBytes(float64(h.memTotal-h.memAvail)), formatBytes(float64(h.memTotal)),
+		formatBytes(h.netRecv), formatBytes(h.netSent),
+		formatBytes(h.diskRead), formatBytes(h.diskWrite))
+}
+
+// formatBytes formats a number of bytes with a binary unit suffix.
+func formatBytes(b float64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	i := 0
+	for b >= 1024 && i < len(units)-1 {
+		b /= 1024
+		i += 1
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
+
+// clip shortens s to at most n characters.
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
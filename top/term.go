@@ -0,0 +1,141 @@
+package top
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// redrawInterval limits how often a busy log stream redraws the screen.
+const redrawInterval = 250 * time.Millisecond
+
+// Run draws the dashboard on the terminal and handles keys until q is
+// pressed or ctx is cancelled.  The terminal is put in raw mode with stty
+// and restored before returning.
+func (d *Dashboard) Run(ctx context.Context, in *os.File, out io.Writer) error {
+	saved, err := stty(in, "-g")
+	if err != nil {
+		return fmt.Errorf("unable to read terminal settings: %s", err)
+	}
+	if _, err := stty(in, "raw", "-echo"); err != nil {
+		return fmt.Errorf("unable to set terminal to raw mode: %s", err)
+	}
+	defer stty(in, saved)
+
+	// alternate screen and hidden cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 32)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			for _, key := range buf[:n] {
+				keys <- key
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+	d.draw(in, out)
+	lastDraw := time.Now()
+	dirty := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case key, ok := <-keys:
+			if !ok || !d.handleKey(key) {
+				return nil
+			}
+			d.draw(in, out)
+			lastDraw = time.Now()
+		case <-d.dirty:
+			dirty = true
+		case <-ticker.C:
+			// redraw at least every second to keep the clock going
+			if dirty || time.Since(lastDraw) >= time.Second {
+				d.draw(in, out)
+				lastDraw = time.Now()
+				dirty = false
+			}
+		}
+	}
+}
+
+// handleKey applies a key press and returns false if the dashboard should
+// exit.
+func (d *Dashboard) handleKey(key byte) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.editing {
+		switch key {
+		case '\r', '\n':
+			d.editing = false
+		case 27:
+			d.editing = false
+			d.filter = ""
+		case 127, '\b':
+			if len(d.filter) > 0 {
+				d.filter = d.filter[:len(d.filter)-1]
+			}
+		case 3:
+			return false
+		default:
+			if key >= ' ' && key < 127 {
+				d.filter += string(key)
+			}
+		}
+		return true
+	}
+
+	switch key {
+	case 'q', 3:
+		return false
+	case '/':
+		d.editing = true
+	case 27:
+		d.filter = ""
+	default:
+		if _, ok := sortKeys[key]; ok {
+			if d.sortBy == key {
+				d.reverse = !d.reverse
+			} else {
+				d.sortBy = key
+				d.reverse = false
+			}
+		}
+	}
+	return true
+}
+
+// draw renders the dashboard at the current terminal size.
+func (d *Dashboard) draw(in *os.File, out io.Writer) {
+	width, height := 80, 24
+	if size, err := stty(in, "size"); err == nil {
+		fmt.Sscanf(size, "%d %d", &height, &width)
+	}
+
+	// raw mode needs explicit carriage returns
+	lines := d.render(width, height)
+	fmt.Fprint(out, "\x1b[H\x1b[2J"+strings.Join(lines, "\r\n"))
+}
+
+// stty runs stty on the terminal in and returns its output.
+func stty(in *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = in
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,231 @@
+/*
+Package top implements hud top, a live terminal dashboard of the containers
+and host hud is watching.  The dashboard is fed by the same metrics
+collections and log records that are sent to the metrics sinks and log
+destinations.
+*/
+package top
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/logger"
+	"github.com/jwilder/hud/metrics"
+)
+
+// maxLines is the number of log lines kept for the log pane.
+const maxLines = 1000
+
+// containerStats is a row of the container table.
+type containerStats struct {
+	name   string
+	image  string
+	cpu    float64
+	rss    int64
+	cache  int64
+	stdout float64
+	stderr float64
+}
+
+// logRate returns the log lines per second of both streams.
+func (c *containerStats) logRate() float64 {
+	return c.stdout + c.stderr
+}
+
+// hostStats is the host summary line.
+type hostStats struct {
+	cpu        float64
+	load       [3]float64
+	memTotal   int64
+	memAvail   int64
+	netRecv    float64
+	netSent    float64
+	diskRead   float64
+	diskWrite  float64
+	hasMetrics bool
+}
+
+type logLine struct {
+	container string
+	text      string
+}
+
+// Dashboard is a metrics Handler and docker LogHandler that renders the
+// metrics and logs it receives to a terminal.
+type Dashboard struct {
+	sync.Mutex
+	prefix     string
+	stale      time.Duration
+	formatter  logger.Formatter
+	containers map[string]*containerStats
+	host       hostStats
+	lastFlush  time.Time
+	lines      []logLine
+	sortBy     byte
+	reverse    bool
+	filter     string
+	editing    bool
+	dirty      chan struct{}
+}
+
+// NewDashboard returns a dashboard for metrics recorded with prefix and
+// flushed every interval seconds.
+func NewDashboard(prefix string, interval int) *Dashboard {
+	f := &logger.ShortFormatter{}
+	f.SetColored(true)
+	return &Dashboard{
+		prefix:     prefix,
+		stale:      3 * time.Duration(interval) * time.Second,
+		formatter:  f,
+		containers: map[string]*containerStats{},
+		sortBy:     'c',
+		dirty:      make(chan struct{}, 1),
+	}
+}
+
+func (d *Dashboard) String() string {
+	return "top"
+}
+
+// SendForever updates the dashboard with each collection until metrics is
+// closed.
+func (d *Dashboard) SendForever(metrics chan *metrics.Collection) {
+	for snap := range metrics {
+		d.update(snap)
+		d.changed()
+	}
+}
+
+// HandleLog adds a log line to the log pane.
+func (d *Dashboard) HandleLog(rec *docker.LogRecord) error {
+	line, err := d.formatter.Format(rec)
+	if err != nil || line == nil {
+		return err
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	d.Lock()
+	d.lines = append(d.lines, logLine{container: rec.ContainerName, text: string(line)})
+	if len(d.lines) > maxLines {
+		d.lines = append([]logLine{}, d.lines[len(d.lines)-maxLines:]...)
+	}
+	d.Unlock()
+	d.changed()
+	return nil
+}
+
+// changed schedules a redraw.
+func (d *Dashboard) changed() {
+	select {
+	case d.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// update replaces the container and host stats with those of a collection.
+// Counters are turned into per second rates over the time since the
+// previous collection.  Gauges of containers that have not been recorded
+// recently are ignored so stopped containers drop off the table.
+func (d *Dashboard) update(snap *metrics.Collection) {
+	d.Lock()
+	defer d.Unlock()
+
+	elapsed := snap.Time().Sub(d.lastFlush).Seconds()
+	if d.lastFlush.IsZero() || elapsed <= 0 {
+		elapsed = 0
+	}
+	d.lastFlush = snap.Time()
+	rate := func(m metrics.Metric) float64 {
+		if elapsed == 0 {
+			return 0
+		}
+		return float64(m.Value().(int64)) / elapsed
+	}
+
+	containers := map[string]*containerStats{}
+	row := func(m metrics.Metric) *containerStats {
+		tags := m.Tags().Map()
+		name := tags["container"]
+		for _, tag := range m.Tags() {
+			// the daemon is a path tag when several daemons are watched
+			if tag.Key == "daemon" && tag.Path {
+				name = tag.Value + "/" + name
+			}
+		}
+		c, ok := containers[name]
+		if !ok {
+			c = &containerStats{name: name}
+			containers[name] = c
+		}
+		if image, ok := tags["image"]; ok {
+			c.image = image
+		}
+		return c
+	}
+
+	host := hostStats{}
+	for _, m := range snap.Metrics() {
+		name := m.Name()
+		if d.prefix != "" {
+			name = strings.TrimPrefix(name, d.prefix+".")
+		}
+
+		if strings.HasPrefix(name, "docker.") && m.Tags().Map()["container"] != "" {
+			ts := m.Time()
+			if ts.IsZero() || snap.Time().Sub(ts) > d.stale {
+				continue
+			}
+		}
+
+		switch name {
+		case "docker.cpu.total":
+			row(m).cpu = m.Value().(float64)
+		case "docker.mem.rss":
+			row(m).rss = m.Value().(int64)
+		case "docker.mem.cache":
+			row(m).cache = m.Value().(int64)
+		case "docker.logs":
+			c := row(m)
+			switch m.Tags().Map()["stream"] {
+			case "stdout":
+				c.stdout = rate(m)
+			case "stderr":
+				c.stderr = rate(m)
+			}
+		case "system.cpu.util.total":
+			if m.Tags().Map()["cpu"] == "all" {
+				host.cpu = m.Value().(float64)
+				host.hasMetrics = true
+			}
+		case "system.load.load1":
+			host.load[0] = m.Value().(float64)
+		case "system.load.load5":
+			host.load[1] = m.Value().(float64)
+		case "system.load.load15":
+			host.load[2] = m.Value().(float64)
+		case "system.mem.total":
+			host.memTotal = m.Value().(int64)
+		case "system.mem.available":
+			host.memAvail = m.Value().(int64)
+		case "system.net.bytes.recv.if":
+			if m.Tags().Map()["interface"] != "lo" {
+				host.netRecv += rate(m)
+			}
+		case "system.net.bytes.sent.if":
+			if m.Tags().Map()["interface"] != "lo" {
+				host.netSent += rate(m)
+			}
+		case "system.disk.bytes.read.dev":
+			host.diskRead += rate(m)
+		case "system.disk.bytes.write.dev":
+			host.diskWrite += rate(m)
+		}
+	}
+
+	d.containers = containers
+	d.host = host
+}
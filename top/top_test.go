@@ -0,0 +1,187 @@
+package top
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jwilder/hud/ansi"
+	"github.com/jwilder/hud/docker"
+	"github.com/jwilder/hud/metrics"
+)
+
+func recordContainer(c *metrics.Collection, name string, cpu float64, rss int64, stdout int64) {
+	tags := metrics.Tags{metrics.PathTag("container", name), metrics.NewTag("image", name+":latest")}
+	c.GetOrRegisterGaugeFloat64("hud.docker.cpu.total", tags...).Set(cpu)
+	c.GetOrRegisterGauge("hud.docker.mem.rss", tags...).Set(rss)
+	c.GetOrRegisterGauge("hud.docker.mem.cache", tags...).Set(rss / 2)
+	c.GetOrRegisterCounter("hud.docker.logs", metrics.PathTag("stream", "stdout"), metrics.PathTag("container", name)).Inc(stdout)
+}
+
+func TestUpdate(t *testing.T) {
+	d := NewDashboard("hud", 2)
+	c := metrics.NewCollection()
+	recordContainer(c, "web", 12.5, 2048, 10)
+	c.GetOrRegisterGaugeFloat64("hud.system.cpu.util.total", metrics.PathTag("cpu", "all")).Set(40)
+	c.GetOrRegisterCounter("hud.system.net.bytes.recv.if", metrics.PathTag("interface", "eth0")).Inc(100)
+	c.GetOrRegisterCounter("hud.system.net.bytes.recv.if", metrics.PathTag("interface", "lo")).Inc(1000)
+
+	d.update(c.Snapshot())
+	web := d.containers["web"]
+	if web == nil {
+		t.Fatalf("expected web to be in the table")
+	}
+	if web.cpu != 12.5 || web.rss != 2048 || web.cache != 1024 || web.image != "web:latest" {
+		t.Fatalf("unexpected stats %+v", web)
+	}
+	if web.stdout != 0 {
+		t.Fatalf("expected no log rate without a previous collection, got %f", web.stdout)
+	}
+	if d.host.cpu != 40 {
+		t.Fatalf("expected host cpu 40, got %f", d.host.cpu)
+	}
+
+	// rates are computed over the time since the previous collection
+	d.lastFlush = time.Now().Add(-2 * time.Second)
+	d.update(c.Snapshot())
+	if rate := d.containers["web"].stdout; rate < 4 || rate > 5.1 {
+		t.Fatalf("expected about 5 lines/s, got %f", rate)
+	}
+	if rate := d.host.netRecv; rate < 40 || rate > 51 {
+		t.Fatalf("expected about 50 B/s excluding lo, got %f", rate)
+	}
+}
+
+func TestUpdateDropsStaleContainers(t *testing.T) {
+	d := NewDashboard("hud", 1)
+	c := metrics.NewCollection()
+	recordContainer(c, "gone", 1, 1, 1)
+
+	d.update(c.Snapshot())
+	if len(d.containers) != 1 {
+		t.Fatalf("expected 1 container, got %d", len(d.containers))
+	}
+
+	// the gauges of the stopped container are no longer recorded
+	time.Sleep(10 * time.Millisecond)
+	d.stale = time.Millisecond
+	d.update(c.Snapshot())
+	if len(d.containers) != 0 {
+		t.Fatalf("expected stopped container to be dropped, got %+v", d.containers)
+	}
+}
+
+func TestUpdateSeveralDaemons(t *testing.T) {
+	d := NewDashboard("", 2)
+	c := metrics.NewCollection()
+	for _, daemon := range []string{"build-1", "build-2"} {
+		tags := metrics.Tags{metrics.PathTag("container", "web"), metrics.PathTag("daemon", daemon)}
+		c.GetOrRegisterGaugeFloat64("docker.cpu.total", tags...).Set(1)
+	}
+
+	d.update(c.Snapshot())
+	if d.containers["build-1/web"] == nil || d.containers["build-2/web"] == nil {
+		t.Fatalf("expected containers of both daemons, got %+v", d.containers)
+	}
+}
+
+func TestSortAndFilter(t *testing.T) {
+	d := NewDashboard("", 2)
+	d.containers = map[string]*containerStats{
+		"web":    {name: "web", cpu: 10, rss: 300},
+		"db":     {name: "db", cpu: 50, rss: 100},
+		"worker": {name: "worker", cpu: 20, rss: 200},
+	}
+
+	names := func() string {
+		rows := []string{}
+		for _, c := range d.rows() {
+			rows = append(rows, c.name)
+		}
+		return strings.Join(rows, ",")
+	}
+
+	if got := names(); got != "db,worker,web" {
+		t.Fatalf("expected cpu order, got %s", got)
+	}
+
+	d.handleKey('m')
+	if got := names(); got != "web,worker,db" {
+		t.Fatalf("expected rss order, got %s", got)
+	}
+
+	d.handleKey('m')
+	if got := names(); got != "db,worker,web" {
+		t.Fatalf("expected reversed rss order, got %s", got)
+	}
+
+	d.handleKey('n')
+	if got := names(); got != "db,web,worker" {
+		t.Fatalf("expected name order, got %s", got)
+	}
+
+	for _, key := range []byte("/w\r") {
+		d.handleKey(key)
+	}
+	if got := names(); got != "web,worker" {
+		t.Fatalf("expected filtered rows, got %s", got)
+	}
+
+	d.handleKey(27)
+	if got := names(); got != "db,web,worker" {
+		t.Fatalf("expected filter to be cleared, got %s", got)
+	}
+
+	if d.handleKey('q') {
+		t.Fatalf("expected q to quit")
+	}
+}
+
+func TestRender(t *testing.T) {
+	d := NewDashboard("", 2)
+	d.containers = map[string]*containerStats{
+		"web": {name: "web", cpu: 10, rss: 3 * 1024 * 1024},
+		"db":  {name: "db", cpu: 50},
+	}
+	for i := 0; i < 50; i++ {
+		d.HandleLog(&docker.LogRecord{Ts: time.Now(), ContainerName: "web", Stream: "stdout", Message: "request"})
+	}
+	d.HandleLog(&docker.LogRecord{Ts: time.Now(), ContainerName: "db", Stream: "stdout", Message: "checkpoint"})
+	d.filter = "web"
+
+	lines := d.render(100, 20)
+	if len(lines) != 20 {
+		t.Fatalf("expected 20 lines, got %d", len(lines))
+	}
+
+	screen := []string{}
+	for _, line := range lines {
+		plain := string(ansi.StripAnsi([]byte(line)))
+		if len([]rune(plain)) > 100 {
+			t.Fatalf("expected lines to fit the width, got %q", plain)
+		}
+		screen = append(screen, plain)
+	}
+
+	text := strings.Join(screen, "\n")
+	if !strings.Contains(text, "3.0M") || strings.Contains(text, "db ") || strings.Contains(text, "checkpoint") {
+		t.Fatalf("expected only the web container and logs, got\n%s", text)
+	}
+	if !strings.Contains(screen[len(screen)-2], "web: request") {
+		t.Fatalf("expected the latest logs above the help line, got\n%s", text)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for b, expected := range map[float64]string{
+		0:                  "0B",
+		1023:               "1023B",
+		1536:               "1.5K",
+		5 * 1024 * 1024:    "5.0M",
+		1024 * 1024 * 1024: "1.0G",
+	} {
+		if got := formatBytes(b); got != expected {
+			t.Errorf("expected %s for %f, got %s", expected, b, got)
+		}
+	}
+}